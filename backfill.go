@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"aztfy-download-counter/config"
+	"aztfy-download-counter/job"
+)
+
+func newBackfillCommand() command {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	common := registerCommonFlags(fs, true)
+	source := fs.String("source", "pmc", "the source to backfill, only pmc keeps the history of downloads")
+	from := fs.String("from", "", "the first day to backfill, e.g. 2023-04-11")
+	to := fs.String("to", "", "the last day to backfill, defaults to today")
+	pmcKustoEndpoint := fs.String("kusto-endpoint", "", "the end point of PMC kusto")
+
+	return command{
+		flags: fs,
+		short: "Collect the download counts of a source for a range of past days.",
+		run: func(ctx context.Context) error {
+			start, end, err := parseDateRange(*from, *to)
+			if err != nil {
+				return err
+			}
+
+			switch *source {
+			case "pmc":
+			case "github", "homebrew":
+				return fmt.Errorf("%s only exposes the current download counts, it can't be backfilled", *source)
+			default:
+				return fmt.Errorf("unknown source %q", *source)
+			}
+
+			cfg, err := common.loadConfig(func(cfg *config.Config) error {
+				if len(*pmcKustoEndpoint) != 0 {
+					cfg.PMC.KustoEndpoint = *pmcKustoEndpoint
+				}
+				// only PMC is backfilled, the config of the other sources doesn't matter.
+				cfg.Github.Enabled = false
+				cfg.Homebrew.Enabled = false
+				cfg.PMC.Enabled = true
+				return nil
+			}, config.Config.Validate)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := withTimeout(ctx, cfg.Timeouts.Run)
+			defer cancel()
+
+			newStore, err := common.storeFactory(ctx, cfg)
+			if err != nil {
+				return fmt.Errorf("init db client error: %+v", err)
+			}
+
+			// the whole range is queried at once, and the total count of a day is based on the previous one in memory.
+			runJob(ctx, cfg, newPMCJob(cfg, start, end, newStore))
+			return ctx.Err()
+		},
+	}
+}
+
+// parseDateRange parses the from and to flags, to defaults to today.
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	if len(from) == 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("-from is required")
+	}
+	start, err := time.Parse(job.TimeFormat, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -from: %+v", err)
+	}
+
+	end, _ := time.Parse(job.TimeFormat, time.Now().UTC().Format(job.TimeFormat))
+	if len(to) != 0 {
+		if end, err = time.Parse(job.TimeFormat, to); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid -to: %+v", err)
+		}
+	}
+
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("-to %s is before -from %s", to, from)
+	}
+	return start, end, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"aztfy-download-counter/config"
+	"aztfy-download-counter/database"
+	"aztfy-download-counter/datasource"
+	"aztfy-download-counter/job"
+	"aztfy-download-counter/job/githubutils"
+	"github.com/ziyeqf/homebrewcalculator"
+)
+
+func newCollectCommand() command {
+	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	common := registerCommonFlags(fs, true)
+	pmcKustoEndpoint := fs.String("kusto-endpoint", "", "the end point of PMC kusto")
+	pmcStartDate := fs.String("pmc-start-date", "", "when the start grabing PMC data, prefer the backfill command for a range of days")
+	sourcesFlag := fs.String("sources", "", "comma separated sources to collect, defaults to the ones enabled in the config file")
+
+	return command{
+		flags: fs,
+		short: "Collect today's download counts of every source and save them.",
+		run: func(ctx context.Context) error {
+			cfg, err := common.loadConfig(func(cfg *config.Config) error {
+				if len(*pmcKustoEndpoint) != 0 {
+					cfg.PMC.KustoEndpoint = *pmcKustoEndpoint
+				}
+				if len(*pmcStartDate) != 0 {
+					cfg.PMC.StartDate = *pmcStartDate
+				}
+				// the sources not selected are disabled, so that their config is not validated.
+				if len(*sourcesFlag) != 0 {
+					selected, err := parseSources(*sourcesFlag)
+					if err != nil {
+						return err
+					}
+					cfg.Github.Enabled = selected["github"]
+					cfg.Homebrew.Enabled = selected["homebrew"]
+					cfg.PMC.Enabled = selected["pmc"]
+				}
+				return nil
+			}, config.Config.Validate)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := withTimeout(ctx, cfg.Timeouts.Run)
+			defer cancel()
+
+			enabled := enabledSources(cfg)
+
+			standardDate := time.Now().UTC().Format(job.TimeFormat)
+
+			newStore, err := common.storeFactory(ctx, cfg)
+			if err != nil {
+				return fmt.Errorf("init db client error: %+v", err)
+			}
+
+			var jobs []job.Job
+			if enabled["github"] {
+				// each repository is counted independently, while they share the rate limit.
+				client, err := newGithubClient(cfg)
+				if err != nil {
+					return err
+				}
+				assets, err := githubutils.NewAssetParsers(cfg.Github.AssetRules())
+				if err != nil {
+					return err
+				}
+				for _, repo := range cfg.Github.Repos {
+					owner, name, _ := config.SplitRepo(repo)
+					jobs = append(jobs, job.GithubWorker{
+						Date: standardDate,
+						StoreInitFunc: func() (database.Store, error) {
+							return newStore("github")
+						},
+						CacheStoreInitFunc: func() (database.Store, error) {
+							return newStore(githubCache)
+						},
+						Logger: newLogger(fmt.Sprintf("[GithubWorker:%s]\t", repo)),
+						Client: client,
+						Owner:  owner,
+						Repo:   name,
+						Assets: assets,
+					})
+				}
+			}
+			if enabled["homebrew"] {
+				w, err := newHomebrewJob(cfg, standardDate, newStore)
+				if err != nil {
+					return err
+				}
+				jobs = append(jobs, w)
+			}
+
+			if enabled["pmc"] {
+				if len(cfg.PMC.StartDate) == 0 {
+					cfg.PMC.StartDate = standardDate
+				}
+
+				d, _ := time.Parse(job.TimeFormat, cfg.PMC.StartDate)
+				n, _ := time.Parse(job.TimeFormat, standardDate)
+				cnt := n.Sub(d).Hours() / 24
+				log.Println("PMC Start Date:", cfg.PMC.StartDate, "Count:", int(cnt)+1)
+				jobs = append(jobs, newPMCJob(cfg, d, n, newStore))
+			}
+
+			var wg sync.WaitGroup
+			for _, w := range jobs {
+				wg.Add(1)
+
+				go func(w job.Job) {
+					defer wg.Done()
+					runJob(ctx, cfg, w)
+				}(w)
+			}
+
+			wg.Wait()
+			// the jobs log their own failures, a cancelled run is reported as a failure of the command.
+			return ctx.Err()
+		},
+	}
+}
+
+// runJob runs w within the job deadline.
+func runJob(ctx context.Context, cfg config.Config, w job.Job) {
+	ctx, cancel := withTimeout(ctx, cfg.Timeouts.Job)
+	defer cancel()
+	w.Run(ctx)
+}
+
+func newHomebrewJob(cfg config.Config, date string, newStore func(source string) (database.Store, error)) (job.Job, error) {
+	spans := make([]homebrewcalculator.Span, 0, len(cfg.Homebrew.Spans))
+	for _, span := range cfg.Homebrew.Spans {
+		spans = append(spans, homebrewcalculator.Span(span))
+	}
+
+	logger := newLogger("[HomebrewWorker]\t")
+	client, err := datasource.NewHomebrewClient(cfg.Homebrew.ApiUrl, cfg.Homebrew.Formula, nil, cfg.Homebrew.Retry.Policy(), logger)
+	if err != nil {
+		return nil, err
+	}
+	return job.HomebrewWorker{
+		Date:   date,
+		Logger: logger,
+		StoreInitFunc: func() (database.Store, error) {
+			return newStore("homebrew")
+		},
+		OsTypes: []database.OsType{
+			database.OsTypeDarwin,
+			database.OsTypeLinux,
+		},
+		Client:  client,
+		Formula: cfg.Homebrew.Formula,
+		Spans:   spans,
+	}, nil
+}
+
+func newGithubClient(cfg config.Config) (*datasource.GithubClient, error) {
+	return datasource.NewGithubClient(cfg.Github.ApiUrl, nil, cfg.Github.Token, cfg.Github.Retry.Policy(), newLogger("[GithubClient]\t"))
+}
+
+// newPMCJob returns the PMC worker counting the days from start to end, both inclusive.
+func newPMCJob(cfg config.Config, start, end time.Time, newStore func(source string) (database.Store, error)) job.Job {
+	logger := newLogger("[PMCWorker]\t")
+	return job.PMCWorker{
+		StartDate: start.Format(job.TimeFormat),
+		Date:      end.Format(job.TimeFormat),
+		StoreInitFunc: func() (database.Store, error) {
+			return newStore("pmc")
+		},
+		SourceInitFunc: func(ctx context.Context) (datasource.PMCSource, error) {
+			return newPMCSource(ctx, cfg, logger)
+		},
+		Logger: logger,
+	}
+}
+
+// newPMCSource returns the source of the PMC access logs, the file of them takes precedence over Kusto.
+func newPMCSource(ctx context.Context, cfg config.Config, logger *log.Logger) (datasource.PMCSource, error) {
+	rules, err := pmcClassRules(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.PMC.AccessLogFile) != 0 {
+		return datasource.NewFilePMCSource(cfg.PMC.AccessLogFile, cfg.PMC.Packages, rules)
+	}
+	retry := cfg.PMC.Retry.Policy()
+	retry.Logger = logger
+	return datasource.NewKustoPMCSource(cfg.PMC.KustoEndpoint, cfg.PMC.Database, cfg.PMC.Packages, rules, retry)
+}
+
+// pmcClassRules returns the class rules of PMC, the ranges of client_ranges_from are fetched from GitHub.
+func pmcClassRules(ctx context.Context, cfg config.Config) ([]datasource.PMCClassRule, error) {
+	return cfg.PMC.ClassRules(func(string) ([]string, error) {
+		client, err := newGithubClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return client.FetchActionsRanges(ctx)
+	})
+}
+
+func enabledSources(cfg config.Config) map[string]bool {
+	return map[string]bool{
+		"github":   cfg.Github.Enabled,
+		"homebrew": cfg.Homebrew.Enabled,
+		"pmc":      cfg.PMC.Enabled,
+	}
+}
+
+// parseSources parses a comma separated list of sources, and returns which of them are enabled.
+func parseSources(list string) (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	for _, source := range strings.Split(list, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+		if len(source) == 0 {
+			continue
+		}
+		if !slices.Contains(sources, source) {
+			return nil, fmt.Errorf("unknown source %q, expect one of %s", source, strings.Join(sources, ", "))
+		}
+		enabled[source] = true
+	}
+
+	if len(enabled) == 0 {
+		return nil, fmt.Errorf("no source is selected")
+	}
+	return enabled, nil
+}
@@ -0,0 +1,428 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"aztfy-download-counter/datasource"
+	"aztfy-download-counter/job/githubutils"
+	"aztfy-download-counter/retry"
+	"gopkg.in/yaml.v3"
+)
+
+const dateFormat = "2006-01-02"
+
+type Config struct {
+	Database DatabaseConfig `yaml:"database"`
+	Github   GithubConfig   `yaml:"github"`
+	Homebrew HomebrewConfig `yaml:"homebrew"`
+	PMC      PMCConfig      `yaml:"pmc"`
+	Timeouts TimeoutsConfig `yaml:"timeouts"`
+}
+
+// TimeoutsConfig are the deadlines of the commands, 0 disables a deadline.
+type TimeoutsConfig struct {
+	// Run limits a whole command.
+	Run time.Duration `yaml:"run"`
+	// Job limits the collection of a source, e.g. a day of PMC.
+	Job time.Duration `yaml:"job"`
+}
+
+type DatabaseConfig struct {
+	// CosmosDBEndpoint is used when SQLitePath is empty.
+	CosmosDBEndpoint string           `yaml:"cosmosdb_endpoint"`
+	SQLitePath       string           `yaml:"sqlite_path"`
+	Name             string           `yaml:"name"`
+	Containers       ContainersConfig `yaml:"containers"`
+	// Retry retries the throttled and the transient failures of the requests to Cosmos DB, its timeout limits every request.
+	Retry RetryConfig `yaml:"retry"`
+}
+
+// ContainersConfig are the names of the Cosmos DB containers, or the SQLite tables.
+type ContainersConfig struct {
+	Github   string `yaml:"github"`
+	Homebrew string `yaml:"homebrew"`
+	PMC      string `yaml:"pmc"`
+	// GithubCache caches the GitHub release lists for conditional requests.
+	GithubCache string `yaml:"github_cache"`
+}
+
+type GithubConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ApiUrl is the base url of the GitHub REST API.
+	ApiUrl string `yaml:"api_url"`
+	// Repos are the repositories to count in the form of owner/repo.
+	Repos []string `yaml:"repos"`
+	// Token authenticates the requests to get a higher rate limit, defaults to the GITHUB_TOKEN environment variable.
+	Token string      `yaml:"token"`
+	Retry RetryConfig `yaml:"retry"`
+	// Assets are the rules parsing the names of the release assets, the first matching one wins.
+	// The assets matching none of them are not counted.
+	Assets []GithubAssetConfig `yaml:"assets"`
+}
+
+type HomebrewConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ApiUrl is the base URL of the formula API, the analytics of the formula are at <api_url>/<formula>.json.
+	ApiUrl  string `yaml:"api_url"`
+	Formula string `yaml:"formula"`
+	// Spans are the analytics periods in days, any of 30, 90 and 365.
+	Spans []int       `yaml:"spans"`
+	Retry RetryConfig `yaml:"retry"`
+}
+
+type PMCConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	KustoEndpoint string `yaml:"kusto_endpoint"`
+	Database      string `yaml:"database"`
+	// Packages are the names of the packages to count, including the former names.
+	Packages  []string    `yaml:"packages"`
+	StartDate string      `yaml:"start_date"`
+	Retry     RetryConfig `yaml:"retry"`
+	// AccessLogFile is a file of HttpAccessLog rows in JSON lines, it's queried instead of Kusto when set.
+	AccessLogFile string `yaml:"access_log_file"`
+	// Classes are the rules classifying the downloads by their clients, the first matching one wins.
+	// The downloads matching none of them are interactive.
+	// By default the downloads from the runners of GitHub Actions are ci, and the dnf downloads in the Fedora containers
+	// are container.
+	Classes []PMCClassConfig `yaml:"classes"`
+}
+
+// GithubAssetConfig is a rule parsing the names of the release assets, see githubutils.AssetRule.
+type GithubAssetConfig struct {
+	Format string `yaml:"format"`
+	// Pattern is a regular expression with the named groups version, os and arch.
+	Pattern string `yaml:"pattern"`
+	// OsType is the OS of the assets whose names don't tell it.
+	OsType string `yaml:"os"`
+	// OsTypes are the OSes the os group may match, they are the partitions of the GitHub items.
+	OsTypes []string `yaml:"os_types"`
+	// Ignore skips the matching assets, e.g. the checksums.
+	Ignore bool `yaml:"ignore"`
+}
+
+// AssetRules returns the rules of the asset parsers.
+func (c GithubConfig) AssetRules() []githubutils.AssetRule {
+	rules := make([]githubutils.AssetRule, 0, len(c.Assets))
+	for _, asset := range c.Assets {
+		rules = append(rules, githubutils.AssetRule(asset))
+	}
+	return rules
+}
+
+func defaultAssets() []GithubAssetConfig {
+	rules := githubutils.DefaultAssetRules()
+	assets := make([]GithubAssetConfig, 0, len(rules))
+	for _, rule := range rules {
+		assets = append(assets, GithubAssetConfig(rule))
+	}
+	return assets
+}
+
+// ClientRangesGithubActions is the ranges of the hosted runners of GitHub Actions, fetched from the meta API of github.api_url.
+const ClientRangesGithubActions = "github-actions"
+
+// PMCClassConfig is a rule of a class of the PMC downloads, see datasource.PMCClassRule.
+type PMCClassConfig struct {
+	Class string `yaml:"class"`
+	// UserAgent is a regular expression matching the user agents of the class.
+	UserAgent string `yaml:"user_agent"`
+	// ClientRanges are the IPv4 or IPv6 ranges of the clients of the class in the CIDR notation.
+	ClientRanges []string `yaml:"client_ranges"`
+	// ClientRangesFrom names the published ranges added to ClientRanges when the source runs, ClientRangesGithubActions
+	// is the only one. They are fetched instead of being configured, as they change over time.
+	ClientRangesFrom string `yaml:"client_ranges_from"`
+}
+
+// ClassRules returns the classification rules of the datasource, fetchRanges returns the ranges of ClientRangesFrom.
+func (c PMCConfig) ClassRules(fetchRanges func(from string) ([]string, error)) ([]datasource.PMCClassRule, error) {
+	rules := make([]datasource.PMCClassRule, 0, len(c.Classes))
+	for _, class := range c.Classes {
+		rule := datasource.PMCClassRule{Class: class.Class, UserAgent: class.UserAgent, ClientRanges: class.ClientRanges}
+		if len(class.ClientRangesFrom) != 0 {
+			ranges, err := fetchRanges(class.ClientRangesFrom)
+			if err != nil {
+				return nil, fmt.Errorf("fetch the client ranges of class %s from %s: %+v", class.Class, class.ClientRangesFrom, err)
+			}
+			rule.ClientRanges = append(append([]string(nil), class.ClientRanges...), ranges...)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// RetryConfig is the retry policy of the calls to a source or the database, the delays are durations like "2s".
+type RetryConfig struct {
+	// MaxAttempts includes the first call, 1 disables the retries.
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	// Timeout limits every attempt, 0 disables it.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Policy returns the retry policy of the config.
+func (r RetryConfig) Policy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts: r.MaxAttempts,
+		BaseDelay:   r.BaseDelay,
+		MaxDelay:    r.MaxDelay,
+		Timeout:     r.Timeout,
+	}
+}
+
+func defaultRetry() RetryConfig {
+	p := retry.DefaultPolicy()
+	return RetryConfig{
+		MaxAttempts: p.MaxAttempts,
+		BaseDelay:   p.BaseDelay,
+		MaxDelay:    p.MaxDelay,
+		Timeout:     p.Timeout,
+	}
+}
+
+// Default returns the configuration of counting aztfexport.
+func Default() Config {
+	// counting the downloads of several days in Kusto takes minutes.
+	pmcRetry := defaultRetry()
+	pmcRetry.Timeout = 10 * time.Minute
+	// the throttled writes are retried sooner, Cosmos DB tells how long to wait.
+	dbRetry := RetryConfig{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+		Timeout:     time.Minute,
+	}
+
+	return Config{
+		Database: DatabaseConfig{
+			Name: "aztfy",
+			Containers: ContainersConfig{
+				Github:      "Github",
+				Homebrew:    "Homebrew",
+				PMC:         "PMC",
+				GithubCache: "GithubCache",
+			},
+			Retry: dbRetry,
+		},
+		Github: GithubConfig{
+			Enabled: true,
+			ApiUrl:  datasource.GithubApiUrl,
+			Repos:   []string{datasource.RepoOwner + "/" + datasource.RepoName},
+			Token:   os.Getenv("GITHUB_TOKEN"),
+			Retry:   defaultRetry(),
+			Assets:  defaultAssets(),
+		},
+		Homebrew: HomebrewConfig{
+			Enabled: true,
+			ApiUrl:  datasource.HomeBrewApiUrl,
+			Formula: datasource.HomeBrewFormula,
+			Spans:   []int{30, 90, 365},
+			Retry:   defaultRetry(),
+		},
+		PMC: PMCConfig{
+			Enabled:  true,
+			Database: datasource.PMCDBName,
+			Packages: []string{"aztfy", "aztfexport"},
+			Retry:    pmcRetry,
+			// apt, yum and zypper don't tell whether they run in CI or a container, the only marker they send is the
+			// variant of the OS in the user agent of dnf, e.g. "libdnf (Fedora Linux 39; container; Linux.x86_64)"
+			// of the Fedora container images. The CI downloads are only classified by the client ranges of the runners,
+			// a container built in CI is ci.
+			Classes: []PMCClassConfig{
+				{
+					Class:            "ci",
+					ClientRangesFrom: ClientRangesGithubActions,
+				},
+				{
+					Class:     "container",
+					UserAgent: `^libdnf5? \([^;]*; container;`,
+				},
+			},
+		},
+		Timeouts: TimeoutsConfig{
+			Run: 6 * time.Hour,
+			Job: time.Hour,
+		},
+	}
+}
+
+// Load reads the configuration file at path on top of the default configuration, then applies the environment
+// variable overrides and validates the result. The file is optional when path is empty.
+func Load(path string) (Config, error) {
+	cfg, err := Read(path)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// Read is Load without the validation, for the callers applying more overrides, e.g. the command line flags.
+func Read(path string) (Config, error) {
+	cfg := Default()
+
+	if len(path) != 0 {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read config file: %+v", err)
+		}
+		if err := yaml.Unmarshal(b, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file %s: %+v", path, err)
+		}
+	}
+
+	if err := applyEnv(&cfg, os.LookupEnv); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// Validate returns all the problems of the database and of the enabled sources at once,
+// it's used by the commands collecting the sources.
+func (c Config) Validate() error {
+	return invalid(append(c.databaseErrors(), c.sourceErrors()...))
+}
+
+// ValidateDatabase returns all the problems of the database at once, it's used by the commands only reading it.
+func (c Config) ValidateDatabase() error {
+	return invalid(c.databaseErrors())
+}
+
+func invalid(errs []error) error {
+	if len(errs) != 0 {
+		return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// checker collects the problems found by check.
+type checker []error
+
+func (c *checker) check(ok bool, format string, a ...interface{}) {
+	if !ok {
+		*c = append(*c, fmt.Errorf(format, a...))
+	}
+}
+
+func (c Config) databaseErrors() []error {
+	var errs checker
+	check := errs.check
+
+	check(c.Database.CosmosDBEndpoint != "" || c.Database.SQLitePath != "", "database: one of cosmosdb_endpoint and sqlite_path must be set")
+	check(c.Database.CosmosDBEndpoint == "" || isURL(c.Database.CosmosDBEndpoint), "database.cosmosdb_endpoint: %q is not a valid URL", c.Database.CosmosDBEndpoint)
+	check(c.Database.Name != "", "database.name: must not be empty")
+	check(c.Database.Containers.Github != "", "database.containers.github: must not be empty")
+	check(c.Database.Containers.Homebrew != "", "database.containers.homebrew: must not be empty")
+	check(c.Database.Containers.PMC != "", "database.containers.pmc: must not be empty")
+	check(c.Database.Containers.GithubCache != "", "database.containers.github_cache: must not be empty")
+	errs = append(errs, c.Database.Retry.validate("database.retry")...)
+	check(c.Timeouts.Run >= 0 && c.Timeouts.Job >= 0, "timeouts: must not be negative")
+	return errs
+}
+
+// sourceErrors returns the problems of the enabled sources.
+func (c Config) sourceErrors() []error {
+	var errs checker
+	check := errs.check
+
+	if c.Github.Enabled {
+		check(isURL(c.Github.ApiUrl), "github.api_url: %q is not a valid URL", c.Github.ApiUrl)
+		check(len(c.Github.Repos) != 0, "github.repos: must not be empty")
+		for _, repo := range c.Github.Repos {
+			_, _, ok := SplitRepo(repo)
+			check(ok, "github.repos: %q is not in the form of owner/repo", repo)
+		}
+		errs = append(errs, c.Github.Retry.validate("github.retry")...)
+		for i, asset := range c.Github.Assets {
+			_, err := githubutils.NewAssetParser(githubutils.AssetRule(asset))
+			check(err == nil, "github.assets[%d].%v", i, err)
+		}
+		if parsers, err := githubutils.NewAssetParsers(c.Github.AssetRules()); err == nil {
+			check(len(parsers.OsTypes()) != 0, "github.assets: no rule counts any asset, all of them are ignored")
+		}
+	}
+
+	if c.Homebrew.Enabled {
+		check(isURL(c.Homebrew.ApiUrl), "homebrew.api_url: %q is not a valid URL", c.Homebrew.ApiUrl)
+		check(c.Homebrew.Formula != "", "homebrew.formula: must not be empty")
+		check(len(c.Homebrew.Spans) != 0, "homebrew.spans: must not be empty")
+		for _, span := range c.Homebrew.Spans {
+			check(span == 30 || span == 90 || span == 365, "homebrew.spans: %d is not one of 30, 90 and 365", span)
+		}
+		errs = append(errs, c.Homebrew.Retry.validate("homebrew.retry")...)
+	}
+
+	if c.PMC.Enabled {
+		check(c.PMC.KustoEndpoint != "" || c.PMC.AccessLogFile != "", "pmc: one of kusto_endpoint and access_log_file must be set")
+		check(c.PMC.KustoEndpoint == "" || isURL(c.PMC.KustoEndpoint), "pmc.kusto_endpoint: %q is not a valid URL", c.PMC.KustoEndpoint)
+		check(c.PMC.Database != "", "pmc.database: must not be empty")
+		check(len(c.PMC.Packages) != 0, "pmc.packages: must not be empty")
+		for _, pkg := range c.PMC.Packages {
+			check(pkg != "", "pmc.packages: must not contain an empty name")
+		}
+		if c.PMC.StartDate != "" {
+			_, err := time.Parse(dateFormat, c.PMC.StartDate)
+			check(err == nil, "pmc.start_date: %q is not in the format of %s", c.PMC.StartDate, dateFormat)
+		}
+		errs = append(errs, c.PMC.Retry.validate("pmc.retry")...)
+		for i, class := range c.PMC.Classes {
+			errs = append(errs, class.validate(fmt.Sprintf("pmc.classes[%d]", i))...)
+		}
+	}
+
+	return errs
+}
+
+func (r RetryConfig) validate(path string) []error {
+	var errs []error
+	if r.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("%s.max_attempts: must be at least 1", path))
+	}
+	if r.BaseDelay < 0 || r.MaxDelay < 0 || r.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("%s: the durations must not be negative", path))
+	}
+	if r.BaseDelay > r.MaxDelay {
+		errs = append(errs, fmt.Errorf("%s.base_delay: %v is longer than max_delay %v", path, r.BaseDelay, r.MaxDelay))
+	}
+	return errs
+}
+
+func (c PMCClassConfig) validate(path string) []error {
+	var errs []error
+	if c.Class == "" || c.Class == datasource.PMCClassInteractive {
+		errs = append(errs, fmt.Errorf("%s.class: must not be empty or %s", path, datasource.PMCClassInteractive))
+	}
+	if c.UserAgent == "" && len(c.ClientRanges) == 0 && c.ClientRangesFrom == "" {
+		errs = append(errs, fmt.Errorf("%s: one of user_agent, client_ranges and client_ranges_from must be set", path))
+	}
+	if _, err := regexp.Compile(c.UserAgent); err != nil {
+		errs = append(errs, fmt.Errorf("%s.user_agent: %+v", path, err))
+	}
+	for _, r := range c.ClientRanges {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			errs = append(errs, fmt.Errorf("%s.client_ranges: %q is not an IP range in the CIDR notation", path, r))
+		}
+	}
+	if c.ClientRangesFrom != "" && c.ClientRangesFrom != ClientRangesGithubActions {
+		errs = append(errs, fmt.Errorf("%s.client_ranges_from: %q is not %s", path, c.ClientRangesFrom, ClientRangesGithubActions))
+	}
+	return errs
+}
+
+// SplitRepo splits a repository in the form of owner/repo.
+func SplitRepo(repo string) (owner string, name string, ok bool) {
+	owner, name, ok = strings.Cut(repo, "/")
+	return owner, name, ok && owner != "" && name != "" && !strings.Contains(name, "/")
+}
+
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
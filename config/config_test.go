@@ -0,0 +1,116 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"aztfy-download-counter/datasource"
+)
+
+func TestValidateRequiresSources(t *testing.T) {
+	cfg := Default()
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("the default config without a storage backend is valid")
+	}
+	for _, want := range []string{"cosmosdb_endpoint and sqlite_path", "kusto_endpoint and access_log_file"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%v doesn't report %s", err, want)
+		}
+	}
+
+	cfg.Database.SQLitePath = "counter.db"
+	if err := cfg.ValidateDatabase(); err != nil {
+		t.Fatalf("the sources are validated with the database: %v", err)
+	}
+	cfg.PMC.AccessLogFile = "access.jsonl"
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.PMC.AccessLogFile = ""
+	cfg.PMC.Enabled = false
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("disabled PMC is validated: %v", err)
+	}
+}
+
+func TestValidateClasses(t *testing.T) {
+	for _, c := range []struct {
+		class PMCClassConfig
+		err   string
+	}{
+		{PMCClassConfig{Class: "ci", ClientRanges: []string{"10.0.0.0/8", "2a01:111:f403::/48"}}, ""},
+		{PMCClassConfig{Class: "ci", ClientRangesFrom: ClientRangesGithubActions}, ""},
+		{PMCClassConfig{Class: "ci", ClientRanges: []string{"10.0.0.1"}}, "not an IP range"},
+		{PMCClassConfig{Class: "ci", ClientRangesFrom: "azure-pipelines"}, "client_ranges_from"},
+		{PMCClassConfig{Class: "ci"}, "one of user_agent, client_ranges and client_ranges_from"},
+	} {
+		errs := c.class.validate("pmc.classes[0]")
+		if c.err == "" && len(errs) != 0 {
+			t.Errorf("%+v: %v", c.class, errs)
+		}
+		if c.err != "" && (len(errs) == 0 || !strings.Contains(errs[0].Error(), c.err)) {
+			t.Errorf("%+v: %v, want an error of %s", c.class, errs, c.err)
+		}
+	}
+}
+
+func TestExampleIsDefault(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	cfg, err := Read("../config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("config.example.yaml differs from the default:\n%+v\n%+v", cfg, Default())
+	}
+}
+
+func TestDefaultClasses(t *testing.T) {
+	for userAgent, want := range map[string]string{
+		"libdnf (Fedora Linux 39; container; Linux.x86_64)":            "container",
+		"libdnf (Red Hat Enterprise Linux 9.3; generic; Linux.x86_64)": "",
+		"Debian APT-HTTP/1.3 (2.4.11)":                                 "",
+	} {
+		got := ""
+		for _, class := range Default().PMC.Classes {
+			if class.UserAgent != "" && regexp.MustCompile(class.UserAgent).MatchString(userAgent) {
+				got = class.Class
+				break
+			}
+		}
+		if got != want {
+			t.Errorf("class of %q is %q, want %q", userAgent, got, want)
+		}
+	}
+}
+
+func TestClassRules(t *testing.T) {
+	ranges := []string{"4.148.0.0/16", "2a01:111:f403::/48"}
+	var from []string
+	rules, err := Default().PMC.ClassRules(func(f string) ([]string, error) {
+		from = append(from, f)
+		return ranges, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(from, []string{ClientRangesGithubActions}) {
+		t.Errorf("fetched the ranges from %v, want only %s", from, ClientRangesGithubActions)
+	}
+	want := []datasource.PMCClassRule{
+		{Class: "ci", ClientRanges: ranges},
+		{Class: "container", UserAgent: `^libdnf5? \([^;]*; container;`},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules %+v, want %+v", rules, want)
+	}
+
+	if _, err := Default().PMC.ClassRules(func(string) ([]string, error) { return nil, errors.New("unreachable") }); err == nil {
+		t.Error("no error when the ranges can't be fetched")
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the configuration.
+// The name of a variable is the yaml path of the field in upper case joined by "_",
+// e.g. AZTFY_DATABASE_COSMOSDB_ENDPOINT overrides database.cosmosdb_endpoint.
+// Lists are comma separated, durations are in the format of "2s". Lists of objects are in JSON with the yaml names of
+// the fields, e.g. AZTFY_PMC_CLASSES='[{"class": "ci", "client_ranges": ["10.0.0.0/8"]}]'.
+const EnvPrefix = "AZTFY"
+
+func applyEnv(cfg *Config, lookupEnv func(string) (string, bool)) error {
+	return applyEnvToStruct(reflect.ValueOf(cfg).Elem(), EnvPrefix, lookupEnv)
+}
+
+func applyEnvToStruct(v reflect.Value, prefix string, lookupEnv func(string) (string, bool)) error {
+	var errs error
+	for i := 0; i < v.NumField(); i++ {
+		tag := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			errs = errors.Join(errs, applyEnvToStruct(field, name, lookupEnv))
+			continue
+		}
+
+		value, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("environment variable %s: %+v", name, err))
+		}
+	}
+	return errs
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int64:
+		if field.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			field.SetInt(int64(d))
+			break
+		}
+		fallthrough
+	case reflect.Int:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.Struct {
+			// JSON is a subset of YAML, the fields are decoded by their yaml tags.
+			slice := reflect.New(field.Type())
+			if err := yaml.Unmarshal([]byte(value), slice.Interface()); err != nil {
+				return err
+			}
+			field.Set(slice.Elem())
+			break
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setField(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
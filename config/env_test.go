@@ -0,0 +1,53 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyEnvLists(t *testing.T) {
+	env := map[string]string{
+		"AZTFY_GITHUB_ASSETS": `[{"format": "zip", "pattern": "^.*_(?P<version>v\\d+\\.\\d+\\.\\d+)_(?P<arch>.+)\\.zip$", "os": "windows"}, {"format": "checksum", "pattern": "\\.sha256$", "ignore": true}]`,
+		"AZTFY_PMC_CLASSES":   `[{"class": "ci", "client_ranges": ["10.0.0.0/8", "192.168.0.0/16"]}]`,
+		"AZTFY_PMC_PACKAGES":  "aztfexport, aztfy",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cfg := Default()
+	if err := applyEnv(&cfg, lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	assets := []GithubAssetConfig{
+		{Format: "zip", Pattern: `^.*_(?P<version>v\d+\.\d+\.\d+)_(?P<arch>.+)\.zip$`, OsType: "windows"},
+		{Format: "checksum", Pattern: `\.sha256$`, Ignore: true},
+	}
+	if !reflect.DeepEqual(cfg.Github.Assets, assets) {
+		t.Errorf("github.assets is %+v, want %+v", cfg.Github.Assets, assets)
+	}
+	classes := []PMCClassConfig{{Class: "ci", ClientRanges: []string{"10.0.0.0/8", "192.168.0.0/16"}}}
+	if !reflect.DeepEqual(cfg.PMC.Classes, classes) {
+		t.Errorf("pmc.classes is %+v, want %+v", cfg.PMC.Classes, classes)
+	}
+	if packages := []string{"aztfexport", "aztfy"}; !reflect.DeepEqual(cfg.PMC.Packages, packages) {
+		t.Errorf("pmc.packages is %v, want %v", cfg.PMC.Packages, packages)
+	}
+}
+
+func TestApplyEnvInvalidList(t *testing.T) {
+	for name, value := range map[string]string{
+		"AZTFY_GITHUB_ASSETS": `[{"format": "zip"`,
+		"AZTFY_PMC_CLASSES":   `{"class": "ci"}`,
+	} {
+		cfg := Default()
+		err := applyEnv(&cfg, func(n string) (string, bool) {
+			return value, n == name
+		})
+		if err == nil {
+			t.Errorf("%s=%s is accepted", name, value)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -13,7 +14,7 @@ const (
 )
 
 type DBItem interface {
-	HomebrewVersion | GithubVersion | PMCVersion
+	HomebrewVersion | GithubVersion | PMCVersion | GithubReleasePage
 }
 
 type HomebrewVersion struct {
@@ -29,9 +30,11 @@ type HomebrewVersion struct {
 
 type GithubVersion struct {
 	Id          string    `json:"id"`
+	Repo        string    `json:"Repo"` // owner/repo
 	Ver         string    `json:"Version"`
 	OsType      string    `json:"OsType"`
 	Arch        string    `json:"Arch"`
+	Format      string    `json:"Format"` // e.g. zip, empty for the items saved before the format was recorded
 	TodayCount  int       `json:"TodayCount"`
 	TotalCount  int       `json:"DownloadCount"`
 	PublishDate time.Time `json:"PublishDate"`
@@ -42,7 +45,42 @@ type PMCVersion struct {
 	Id         string `json:"id"`
 	Ver        string `json:"Version"`
 	Arch       string `json:"Arch"`
+	Format     string `json:"Format"` // rpm or deb, empty for the rpm items saved before deb was counted
 	TodayCount int    `json:"TodayCount"`
 	TotalCount int64  `json:"TotalCount"`
 	Date       string `json:"Date"`
+	// TodayClients estimates the distinct clients of TodayCount, so that the reinstalls of e.g. CI don't inflate it.
+	// It's summed up from the paths of the version-arch, a client downloading from more than one distro is counted more than once.
+	// It's 0 when the access log doesn't tell the clients, and it isn't totaled as the clients of the days overlap.
+	TodayClients int `json:"TodayClients"`
+	// Classes breaks TodayCount down by the classes of the clients, e.g. ci, container and interactive.
+	Classes []PMCClass `json:"Classes"`
+	// Distros breaks TodayCount down by the distro releases whose repositories the package is downloaded from.
+	Distros []PMCDistro `json:"Distros"`
+}
+
+// PMCClass is the downloads of a version-arch by a class of clients in a day.
+type PMCClass struct {
+	Class        string `json:"Class"`
+	TodayCount   int    `json:"TodayCount"`
+	TodayClients int    `json:"TodayClients"`
+}
+
+// PMCDistro is the downloads of a version-arch from the repositories of a distro release in a day.
+type PMCDistro struct {
+	Distro       string `json:"Distro"` // e.g. ubuntu, empty when the repository layout is unknown
+	Release      string `json:"Release"`
+	TodayCount   int    `json:"TodayCount"`
+	TodayClients int    `json:"TodayClients"`
+}
+
+// GithubReleasePage is a page of the release list of a repository, cached for conditional requests.
+type GithubReleasePage struct {
+	Id           string          `json:"id"`
+	Repo         string          `json:"Repo"` // owner/repo
+	Page         int             `json:"Page"`
+	ETag         string          `json:"ETag"`
+	LastModified string          `json:"LastModified"`
+	Releases     json.RawMessage `json:"Releases"`
+	FetchDate    string          `json:"Date"`
 }
@@ -2,10 +2,15 @@ package database
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"time"
 
+	"aztfy-download-counter/retry"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 )
@@ -29,74 +34,92 @@ func AuthDBClient(endpoint string, dbName string) (*azcosmos.DatabaseClient, err
 	return dbClient, nil
 }
 
-func CreateOrUpdateItem[T DBItem](ctx context.Context, container *azcosmos.ContainerClient, osType string, item T) error {
-	pk := azcosmos.NewPartitionKeyString(osType)
+const (
+	// cosmosMaxBatchOperations is the limit of the operations in a transactional batch.
+	cosmosMaxBatchOperations = 100
+	// cosmosMaxBatchBytes is the limit of the items in a transactional batch, the request is limited to 2MB,
+	// the rest is left for the operation envelopes.
+	cosmosMaxBatchBytes = 1800 * 1024
+)
 
-	b, err := json.Marshal(item)
-	if err != nil {
-		return err
-	}
+// CosmosStore is a Store backed by a Cosmos DB container.
+// The requests are retried by the retry policy when they are throttled or fail transiently.
+type CosmosStore struct {
+	container *azcosmos.ContainerClient
+	retry     retry.Policy
+}
 
-	itemOptions := azcosmos.ItemOptions{
-		ConsistencyLevel: azcosmos.ConsistencyLevelSession.ToPtr(),
+var _ Store = CosmosStore{}
+
+// NewCosmosStore returns the store of a container, the timeout of policy limits every request to the backend.
+func NewCosmosStore(dbClient *azcosmos.DatabaseClient, containerName string, policy retry.Policy) (CosmosStore, error) {
+	if dbClient == nil {
+		return CosmosStore{}, errors.New("cosmos db client is not initialized")
 	}
 
-	_, err = container.UpsertItem(ctx, pk, b, &itemOptions)
+	container, err := dbClient.NewContainer(containerName)
 	if err != nil {
-		return err
+		return CosmosStore{}, err
 	}
 
-	return nil
+	return CosmosStore{container: container, retry: policy}, nil
 }
 
-func QueryItem[T DBItem](ctx context.Context, container *azcosmos.ContainerClient, osType, date string, response T) (resp []T, err error) {
-	pk := azcosmos.NewPartitionKeyString(osType)
-
-	opt := azcosmos.QueryOptions{
-		QueryParameters: []azcosmos.QueryParameter{
-			{"@ostype", osType},
-			{"@cntDate", date},
-		},
+func (s CosmosStore) Upsert(ctx context.Context, pk string, item []byte) error {
+	itemOptions := azcosmos.ItemOptions{
+		ConsistencyLevel: azcosmos.ConsistencyLevelSession.ToPtr(),
 	}
 
-	queryPager := container.NewQueryItemsPager("select * from c where c.OsType = @ostype AND c.Date = @cntDate", pk, &opt)
+	return s.retry.Do(ctx, retryable, func(ctx context.Context) error {
+		_, err := s.container.UpsertItem(ctx, azcosmos.NewPartitionKeyString(pk), item, &itemOptions)
+		return cosmosError(err)
+	})
+}
 
-	var errs error
-	for queryPager.More() {
-		queryResponse, err := queryPager.NextPage(ctx)
-		if err != nil {
-			errs = errors.Join(err)
-		}
+// BatchUpsert splits the items into transactional batches within the limits of operations and size.
+// The batches are written independently, a failed one doesn't stop the others.
+func (s CosmosStore) BatchUpsert(ctx context.Context, pk string, items [][]byte) (BatchSummary, error) {
+	var summary BatchSummary
+	for _, chunk := range chunkBatch(items, cosmosMaxBatchOperations, cosmosMaxBatchBytes) {
+		err := s.retry.Do(ctx, retryable, func(ctx context.Context) error {
+			return s.executeBatch(ctx, pk, chunk)
+		})
+		summary = append(summary, newChunkResult(chunk, err))
+	}
 
-		for _, item := range queryResponse.Items {
-			err := json.Unmarshal(item, &response)
-			if err != nil {
-				errs = errors.Join(err)
-				continue
-			}
-			resp = append(resp, response)
+	return summary, summary.err(pk)
+}
+
+// chunkBatch splits items into chunks of at most maxOps items and maxBytes bytes, keeping their order.
+// An item larger than maxBytes is put in a chunk of its own.
+func chunkBatch(items [][]byte, maxOps, maxBytes int) [][][]byte {
+	var chunks [][][]byte
+	var chunk [][]byte
+	size := 0
+	for _, item := range items {
+		if len(chunk) != 0 && (len(chunk) == maxOps || size+len(item) > maxBytes) {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
 		}
+		chunk = append(chunk, item)
+		size += len(item)
 	}
-	return resp, errs
+	if len(chunk) != 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
 }
 
-func BatchUpsert[T DBItem](ctx context.Context, container *azcosmos.ContainerClient, pkStr string, items []T) error {
-	pk := azcosmos.NewPartitionKeyString(pkStr)
-
-	batch := container.NewTransactionalBatch(pk)
+func (s CosmosStore) executeBatch(ctx context.Context, pk string, items [][]byte) error {
+	batch := s.container.NewTransactionalBatch(azcosmos.NewPartitionKeyString(pk))
 
 	for _, item := range items {
-		b, err := json.Marshal(item)
-		if err != nil {
-			return err
-		}
-
-		batch.UpsertItem(b, nil)
+		batch.UpsertItem(item, nil)
 	}
 
-	response, err := container.ExecuteTransactionalBatch(ctx, batch, nil)
+	response, err := s.container.ExecuteTransactionalBatch(ctx, batch, nil)
 	if err != nil {
-		return err
+		return cosmosError(err)
 	}
 
 	if !response.Success {
@@ -104,24 +127,88 @@ func BatchUpsert[T DBItem](ctx context.Context, container *azcosmos.ContainerCli
 		for _, item := range response.OperationResults {
 			err = errors.Join(err, fmt.Errorf("insert failed, code: %v", item.StatusCode))
 		}
+		// the cause of the failure is the first operation which isn't a dependency failure.
+		for _, item := range response.OperationResults {
+			if item.StatusCode != http.StatusFailedDependency {
+				return errorFromStatus(int(item.StatusCode), response.RawResponse.Header, err)
+			}
+		}
 		return err
 	}
 
 	return nil
 }
 
-func ReadItem[T DBItem](ctx context.Context, container *azcosmos.ContainerClient, pkStr, itemId string, response *T) error {
-	pk := azcosmos.NewPartitionKeyString(pkStr)
+func (s CosmosStore) Read(ctx context.Context, pk, id string) ([]byte, error) {
+	var item []byte
+	err := s.retry.Do(ctx, retryable, func(ctx context.Context) error {
+		itemResponse, err := s.container.ReadItem(ctx, azcosmos.NewPartitionKeyString(pk), id, nil)
+		if err != nil {
+			return cosmosError(err)
+		}
+		item = itemResponse.Value
+		return nil
+	})
+	return item, err
+}
 
-	itemResponse, err := container.ReadItem(ctx, pk, itemId, nil)
-	if err != nil {
-		return err
+func (s CosmosStore) Query(ctx context.Context, pk, date string) ([][]byte, error) {
+	opt := azcosmos.QueryOptions{
+		QueryParameters: []azcosmos.QueryParameter{
+			{Name: "@cntDate", Value: date},
+		},
 	}
 
-	err = json.Unmarshal(itemResponse.Value, &response)
-	if err != nil {
+	queryPager := s.container.NewQueryItemsPager("select * from c where c.Date = @cntDate", azcosmos.NewPartitionKeyString(pk), &opt)
+
+	var items [][]byte
+	var errs error
+	for queryPager.More() {
+		queryResponse, err := s.nextPage(ctx, queryPager)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			break
+		}
+
+		items = append(items, queryResponse.Items...)
+	}
+	return items, errs
+}
+
+// nextPage gets the next page of the query, a failed page is fetched again by the retries.
+func (s CosmosStore) nextPage(ctx context.Context, pager *runtime.Pager[azcosmos.QueryItemsResponse]) (azcosmos.QueryItemsResponse, error) {
+	var resp azcosmos.QueryItemsResponse
+	err := s.retry.Do(ctx, retryable, func(ctx context.Context) error {
+		var err error
+		if resp, err = pager.NextPage(ctx); err != nil {
+			return cosmosError(err)
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// retryable is the retry.Classifier of Cosmos DB, the throttled requests wait for the retry-after hint of the backend.
+func retryable(err error) (time.Duration, bool) {
+	var throttledErr *ThrottledError
+	if errors.As(err, &throttledErr) {
+		return throttledErr.RetryAfter, true
+	}
+	var transientErr *TransientError
+	var netErr net.Error
+	return 0, errors.As(err, &transientErr) || errors.As(err, &netErr)
+}
+
+// cosmosError converts the errors of the Cosmos DB SDK into the error types of this package.
+func cosmosError(err error) error {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
 		return err
 	}
 
-	return nil
+	var header http.Header
+	if respErr.RawResponse != nil {
+		header = respErr.RawResponse.Header
+	}
+	return errorFromStatus(respErr.StatusCode, header, err)
 }
@@ -0,0 +1,27 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCosmosRetryable(t *testing.T) {
+	if wait, ok := retryable(&ThrottledError{RetryAfter: time.Second}); !ok || wait != time.Second {
+		t.Errorf("throttled: %v, %v", wait, ok)
+	}
+	if _, ok := retryable(&TransientError{Err: errors.New("unavailable")}); !ok {
+		t.Error("transient error is not retried")
+	}
+	if _, ok := retryable(notFoundError("pk", "id")); ok {
+		t.Error("not found error is retried")
+	}
+}
+
+func TestChunkBatch(t *testing.T) {
+	items := [][]byte{[]byte("aa"), []byte("bb"), []byte("cccc"), []byte("d")}
+	chunks := chunkBatch(items, 2, 4)
+	if len(chunks) != 3 || len(chunks[0]) != 2 || len(chunks[1]) != 1 || len(chunks[2]) != 1 {
+		t.Fatalf("chunks %q", chunks)
+	}
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the requested item doesn't exist.
+var ErrNotFound = errors.New("NotFound")
+
+// ThrottledError is returned when the backend rejects the request for exceeding its rate limit.
+type ThrottledError struct {
+	// RetryAfter is how long the backend asks to wait before retrying, 0 if it doesn't tell.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("throttled, retry after %v: %v", e.RetryAfter, e.Err)
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return e.Err
+}
+
+// ConflictError is returned when the request conflicts with the current state of an item.
+type ConflictError struct {
+	Err error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict: %v", e.Err)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned when the credential is rejected or lacks permission.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("unauthorized: %v", e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// TransientError is returned when the backend fails temporarily, the request is expected to succeed on retry.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return fmt.Sprintf("transient failure: %v", e.Err)
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// ItemError is the failure of writing a single item.
+type ItemError struct {
+	Id  string
+	Err error
+}
+
+// BatchError is returned when some items of a batch are not written after the retries, the others are written.
+type BatchError struct {
+	Partition string
+	// Total is the count of the items in the batch.
+	Total  int
+	Failed []ItemError
+}
+
+func (e *BatchError) Error() string {
+	ids := make([]string, 0, len(e.Failed))
+	for _, item := range e.Failed {
+		ids = append(ids, item.Id)
+	}
+	return fmt.Sprintf("%d of %d items in partition %s failed (%s): %v", len(e.Failed), e.Total, e.Partition, strings.Join(ids, ", "), e.Failed[0].Err)
+}
+
+// Unwrap returns the errors of the failed items, so that they can be checked by errors.Is and errors.As.
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, item := range e.Failed {
+		errs = append(errs, item.Err)
+	}
+	return errs
+}
+
+func notFoundError(pk, id string) error {
+	return fmt.Errorf("item %s in partition %s: %w", id, pk, ErrNotFound)
+}
+
+// statusRetryWith is returned by Cosmos DB when concurrent operations on an item conflict, they should be retried.
+const statusRetryWith = 449
+
+// errorFromStatus classifies err by the HTTP status code of the response which caused it.
+// header is the response header, it's used to read the retry-after hint of a throttled response.
+func errorFromStatus(statusCode int, header http.Header, err error) error {
+	switch statusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case http.StatusTooManyRequests:
+		return &ThrottledError{RetryAfter: retryAfter(header), Err: err}
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return &ConflictError{Err: err}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &AuthError{Err: err}
+	case http.StatusRequestTimeout, statusRetryWith,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &TransientError{Err: err}
+	default:
+		return err
+	}
+}
+
+func retryAfter(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms, err := strconv.ParseFloat(header.Get("x-ms-retry-after-ms"), 64); err == nil {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	if s, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	return 0
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a Store keeping the items in memory, it's used by tests and dry runs.
+// Like Cosmos DB, an item is only accepted by the partition its partition key path points to.
+type MemoryStore struct {
+	// Base is read when an item is not in memory, it's never written to. Optional.
+	Base Store
+	// Logger prints every written item. Optional.
+	Logger *log.Logger
+
+	pkPath string
+	mu     *sync.RWMutex
+	// [pk][id]item
+	items map[string]map[string][]byte
+}
+
+var _ Store = MemoryStore{}
+
+func NewMemoryStore(pkPath string) MemoryStore {
+	return MemoryStore{
+		pkPath: pkPath,
+		mu:     &sync.RWMutex{},
+		items:  make(map[string]map[string][]byte),
+	}
+}
+
+// NewDryRunStore returns a MemoryStore which reads from base, but only prints what would have been written to it.
+func NewDryRunStore(base Store, pkPath string, logger *log.Logger) MemoryStore {
+	s := NewMemoryStore(pkPath)
+	s.Base = base
+	s.Logger = logger
+	return s
+}
+
+func (s MemoryStore) Upsert(_ context.Context, pk string, item []byte) error {
+	return s.batchUpsert(pk, [][]byte{item})
+}
+
+func (s MemoryStore) BatchUpsert(_ context.Context, pk string, items [][]byte) (BatchSummary, error) {
+	summary := BatchSummary{newChunkResult(items, s.batchUpsert(pk, items))}
+	return summary, summary.err(pk)
+}
+
+func (s MemoryStore) batchUpsert(pk string, items [][]byte) error {
+	keys := make([]itemKey, 0, len(items))
+	for _, item := range items {
+		key, err := parseItemKey(item)
+		if err != nil {
+			return err
+		}
+		if err := s.checkPartitionKey(pk, item); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[pk]; !ok {
+		s.items[pk] = make(map[string][]byte)
+	}
+	for i, item := range items {
+		s.items[pk][keys[i].Id] = item
+		if s.Logger != nil {
+			s.Logger.Printf("upsert %s: %s", pk, item)
+		}
+	}
+
+	return nil
+}
+
+func (s MemoryStore) Read(ctx context.Context, pk, id string) ([]byte, error) {
+	s.mu.RLock()
+	item, ok := s.items[pk][id]
+	s.mu.RUnlock()
+	if ok {
+		return item, nil
+	}
+
+	if s.Base != nil {
+		return s.Base.Read(ctx, pk, id)
+	}
+
+	return nil, notFoundError(pk, id)
+}
+
+func (s MemoryStore) Query(ctx context.Context, pk, date string) ([][]byte, error) {
+	// [id]item
+	result := make(map[string][]byte)
+
+	if s.Base != nil {
+		items, err := s.Base.Query(ctx, pk, date)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			key, err := parseItemKey(item)
+			if err != nil {
+				return nil, err
+			}
+			result[key.Id] = item
+		}
+	}
+
+	s.mu.RLock()
+	for id, item := range s.items[pk] {
+		key, err := parseItemKey(item)
+		if err != nil {
+			s.mu.RUnlock()
+			return nil, err
+		}
+		if key.Date == date {
+			result[id] = item
+		}
+	}
+	s.mu.RUnlock()
+
+	ids := make([]string, 0, len(result))
+	for id := range result {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	items := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		items = append(items, result[id])
+	}
+	return items, nil
+}
+
+func (s MemoryStore) checkPartitionKey(pk string, item []byte) error {
+	if s.pkPath == "" {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(item, &fields); err != nil {
+		return err
+	}
+	if v, ok := fields[s.pkPath].(string); !ok || v != pk {
+		return fmt.Errorf("partition key %q doesn't match the %s of the item: %v", pk, s.pkPath, fields[s.pkPath])
+	}
+
+	return nil
+}
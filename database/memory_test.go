@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(HomebrewPartitionKey)
+
+	item := HomebrewVersion{Id: "2024-01-02-darwin", OsType: "darwin", TodayCount: 1, CountDate: "2024-01-02"}
+	if err := CreateOrUpdateItem(ctx, store, "darwin", item); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateOrUpdateItem(ctx, store, "linux", item); err == nil {
+		t.Fatal("upsert into another partition succeeded")
+	}
+
+	var got HomebrewVersion
+	if err := ReadItem(ctx, store, "darwin", item.Id, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != item {
+		t.Fatalf("read %+v, want %+v", got, item)
+	}
+	if err := ReadItem(ctx, store, "linux", item.Id, &got); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("read from another partition: %v, want ErrNotFound", err)
+	}
+
+	items, err := QueryItem(ctx, store, "darwin", "2024-01-02", HomebrewVersion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(items, []HomebrewVersion{item}) {
+		t.Fatalf("query %+v, want [%+v]", items, item)
+	}
+	if items, _ := QueryItem(ctx, store, "darwin", "2024-01-03", HomebrewVersion{}); len(items) != 0 {
+		t.Fatalf("query of another date: %+v", items)
+	}
+}
+
+func TestDryRunStore(t *testing.T) {
+	ctx := context.Background()
+	base := NewMemoryStore(PMCPartitionKey)
+	saved := PMCVersion{Id: "a", Arch: "x86_64", TodayCount: 1, Date: "2024-01-02"}
+	if err := CreateOrUpdateItem(ctx, base, saved.Arch, saved); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewDryRunStore(base, PMCPartitionKey, nil)
+	written := PMCVersion{Id: "b", Arch: "x86_64", TodayCount: 2, Date: "2024-01-02"}
+	if err := CreateOrUpdateItem(ctx, store, written.Arch, written); err != nil {
+		t.Fatal(err)
+	}
+
+	var got PMCVersion
+	if err := ReadItem(ctx, store, "x86_64", "a", &got); err != nil || got.TodayCount != 1 {
+		t.Fatalf("read through the base: %+v, %v", got, err)
+	}
+	if err := ReadItem(ctx, base, "x86_64", "b", &got); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("dry run wrote into the base: %v", err)
+	}
+
+	items, err := QueryItem(ctx, store, "x86_64", "2024-01-02", PMCVersion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Id != "a" || items[1].Id != "b" {
+		t.Fatalf("query %+v, want the items a and b", items)
+	}
+}
@@ -0,0 +1,426 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var sqliteTableNameReg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// itemKey holds the fields every stored item has in common.
+type itemKey struct {
+	Id   string `json:"id"`
+	Date string `json:"Date"`
+}
+
+func parseItemKey(item []byte) (itemKey, error) {
+	var key itemKey
+	if err := json.Unmarshal(item, &key); err != nil {
+		return key, err
+	}
+	if key.Id == "" {
+		return key, errors.New("item has no id")
+	}
+	return key, nil
+}
+
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %q: %+v", path, err)
+	}
+
+	// sqlite only allows a single writer, the workers are running concurrently.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+// OpenSQLiteReadOnly opens an existing database file without writing to it, e.g. for the dry runs.
+func OpenSQLiteReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %q: %+v", path, err)
+	}
+	return OpenSQLite((&url.URL{Scheme: "file", Opaque: path, RawQuery: "mode=ro"}).String())
+}
+
+// sqliteColumnKind is how a field of an item is saved in a column.
+type sqliteColumnKind int
+
+const (
+	sqliteText sqliteColumnKind = iota
+	sqliteInteger
+	sqliteBool
+	// sqliteJSON saves the lists and the objects as JSON text.
+	sqliteJSON
+)
+
+// sqliteColumn is a column of a field of an item, named by the JSON name of the field.
+type sqliteColumn struct {
+	name string
+	kind sqliteColumnKind
+}
+
+func (c sqliteColumn) sqlType() string {
+	switch c.kind {
+	case sqliteInteger, sqliteBool:
+		return "INTEGER"
+	default:
+		return "TEXT"
+	}
+}
+
+// sqliteColumns returns the columns of the fields of t, in the order of the fields.
+func sqliteColumns(t reflect.Type) []sqliteColumn {
+	var columns []sqliteColumn
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		column := sqliteColumn{name: name, kind: sqliteJSON}
+		switch {
+		case field.Type == reflect.TypeOf(time.Time{}):
+			column.kind = sqliteText
+		case field.Type.Kind() == reflect.String:
+			column.kind = sqliteText
+		case field.Type.Kind() == reflect.Bool:
+			column.kind = sqliteBool
+		case field.Type.Kind() >= reflect.Int && field.Type.Kind() <= reflect.Uint64:
+			column.kind = sqliteInteger
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// SQLiteStore is a Store backed by a table of a SQLite database, one table per container.
+// Every field of the items is a column of the table, named by its JSON name, the lists and the objects are saved as JSON.
+// Items are keyed by partition key and id, the same way as Cosmos DB does.
+type SQLiteStore struct {
+	db     *sql.DB
+	table  string
+	pkPath string
+	// columns of the fields of the items, including id, Date and the partition key.
+	columns []sqliteColumn
+	// readOnly rejects the writes, the table is not created by the store.
+	readOnly bool
+}
+
+var _ Store = SQLiteStore{}
+
+// NewSQLiteStore returns a store of the items of type T in table, partitioned by their field pkPath, e.g. OsType.
+// The table is created, or the columns of the new fields are added to it.
+func NewSQLiteStore[T DBItem](ctx context.Context, db *sql.DB, table string, pkPath string) (SQLiteStore, error) {
+	s, err := newSQLiteStore[T](db, table, pkPath)
+	if err != nil {
+		return SQLiteStore{}, err
+	}
+
+	if err := s.createTable(ctx); err != nil {
+		return SQLiteStore{}, fmt.Errorf("failed to create table %s: %+v", table, err)
+	}
+	return s, nil
+}
+
+// NewReadOnlySQLiteStore is NewSQLiteStore of an existing table, which is never changed. The fields of the items
+// added since the table was created are left out. ErrNotFound is returned when the table doesn't exist.
+func NewReadOnlySQLiteStore[T DBItem](ctx context.Context, db *sql.DB, table string, pkPath string) (SQLiteStore, error) {
+	s, err := newSQLiteStore[T](db, table, pkPath)
+	if err != nil {
+		return SQLiteStore{}, err
+	}
+
+	existing, err := s.existingColumns(ctx, s.db)
+	if err != nil {
+		return SQLiteStore{}, fmt.Errorf("failed to read table %s: %+v", table, err)
+	}
+	if len(existing) == 0 {
+		return SQLiteStore{}, fmt.Errorf("table %s: %w", table, ErrNotFound)
+	}
+	columns := make([]sqliteColumn, 0, len(s.columns))
+	for _, c := range s.columns {
+		if existing[c.name] {
+			columns = append(columns, c)
+		}
+	}
+	s.columns = columns
+	s.readOnly = true
+	return s, nil
+}
+
+func newSQLiteStore[T DBItem](db *sql.DB, table string, pkPath string) (SQLiteStore, error) {
+	if db == nil {
+		return SQLiteStore{}, errors.New("sqlite database is not initialized")
+	}
+	if !sqliteTableNameReg.MatchString(table) {
+		return SQLiteStore{}, fmt.Errorf("invalid table name %q", table)
+	}
+
+	s := SQLiteStore{
+		db:      db,
+		table:   table,
+		pkPath:  pkPath,
+		columns: sqliteColumns(reflect.TypeOf((*T)(nil)).Elem()),
+	}
+	for _, required := range []string{"id", "Date", pkPath} {
+		if !s.hasColumn(required) {
+			return SQLiteStore{}, fmt.Errorf("items of table %s have no field %s", table, required)
+		}
+	}
+	return s, nil
+}
+
+func (s SQLiteStore) hasColumn(name string) bool {
+	for _, c := range s.columns {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s SQLiteStore) createTable(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.existingColumns(ctx, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	if len(existing) == 0 {
+		defs := make([]string, 0, len(s.columns)+1)
+		for _, c := range s.columns {
+			defs = append(defs, fmt.Sprintf("%q %s", c.name, c.sqlType()))
+		}
+		defs = append(defs, fmt.Sprintf(`PRIMARY KEY (%q, "id")`, s.pkPath))
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", s.table, strings.Join(defs, ",\n\t"))); err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	} else {
+		// the fields added since the table was created.
+		for _, c := range s.columns {
+			if existing[c.name] {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %q %s", s.table, c.name, c.sqlType())); err != nil {
+				return errors.Join(err, tx.Rollback())
+			}
+		}
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_pk_date ON %[1]s (%[2]q, "Date")`, s.table, s.pkPath)); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// existingColumns returns the columns of the table, it's empty when the table doesn't exist.
+func (s SQLiteStore) existingColumns(ctx context.Context, db sqlQueryer) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", s.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+func (s SQLiteStore) Upsert(ctx context.Context, pk string, item []byte) error {
+	return s.upsert(ctx, s.db, pk, item)
+}
+
+// BatchUpsert writes the items in a single transaction, SQLite doesn't limit its size.
+func (s SQLiteStore) BatchUpsert(ctx context.Context, pk string, items [][]byte) (BatchSummary, error) {
+	summary := BatchSummary{newChunkResult(items, s.batchUpsert(ctx, pk, items))}
+	return summary, summary.err(pk)
+}
+
+func (s SQLiteStore) batchUpsert(ctx context.Context, pk string, items [][]byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := s.upsert(ctx, tx, pk, item); err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s SQLiteStore) Read(ctx context.Context, pk, id string) ([]byte, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE %q = ? AND "id" = ?`, s.columnList(), s.table, s.pkPath), pk, id)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.scanItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, notFoundError(pk, id)
+	}
+
+	return items[0], nil
+}
+
+func (s SQLiteStore) Query(ctx context.Context, pk, date string) ([][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE %q = ? AND "Date" = ? ORDER BY "id"`, s.columnList(), s.table, s.pkPath), pk, date)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanItems(rows)
+}
+
+func (s SQLiteStore) columnList() string {
+	names := make([]string, 0, len(s.columns))
+	for _, c := range s.columns {
+		names = append(names, fmt.Sprintf("%q", c.name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// scanItems converts the rows back into the JSON of the items, the NULL columns are left out.
+func (s SQLiteStore) scanItems(rows *sql.Rows) ([][]byte, error) {
+	defer rows.Close()
+
+	var items [][]byte
+	for rows.Next() {
+		values := make([]any, len(s.columns))
+		for i, c := range s.columns {
+			switch c.kind {
+			case sqliteInteger:
+				values[i] = &sql.NullInt64{}
+			case sqliteBool:
+				values[i] = &sql.NullBool{}
+			default:
+				values[i] = &sql.NullString{}
+			}
+		}
+		if err := rows.Scan(values...); err != nil {
+			return items, err
+		}
+
+		fields := make(map[string]any, len(s.columns))
+		for i, c := range s.columns {
+			switch v := values[i].(type) {
+			case *sql.NullInt64:
+				if v.Valid {
+					fields[c.name] = v.Int64
+				}
+			case *sql.NullBool:
+				if v.Valid {
+					fields[c.name] = v.Bool
+				}
+			case *sql.NullString:
+				if !v.Valid {
+					continue
+				}
+				if c.kind == sqliteJSON {
+					fields[c.name] = json.RawMessage(v.String)
+				} else {
+					fields[c.name] = v.String
+				}
+			}
+		}
+		item, err := json.Marshal(fields)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s SQLiteStore) upsert(ctx context.Context, db sqlExecer, pk string, item []byte) error {
+	if s.readOnly {
+		return fmt.Errorf("table %s is read only", s.table)
+	}
+	if _, err := parseItemKey(item); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(item, &fields); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(s.columns))
+	updates := make([]string, 0, len(s.columns))
+	args := make([]any, 0, len(s.columns))
+	for _, c := range s.columns {
+		v, err := s.columnValue(c, fields[c.name])
+		if err != nil {
+			return fmt.Errorf("field %s: %+v", c.name, err)
+		}
+		if c.name == s.pkPath && v != pk {
+			return fmt.Errorf("partition key %q doesn't match the %s of the item: %v", pk, s.pkPath, v)
+		}
+		names = append(names, fmt.Sprintf("%q", c.name))
+		updates = append(updates, fmt.Sprintf("%[1]q = excluded.%[1]q", c.name))
+		args = append(args, v)
+	}
+
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (%s) VALUES (?%s)
+ON CONFLICT (%q, "id") DO UPDATE SET %s`, s.table, strings.Join(names, ", "), strings.Repeat(", ?", len(names)-1), s.pkPath, strings.Join(updates, ", ")), args...)
+	return err
+}
+
+// columnValue converts the JSON value of a field into the value of its column, a missing field is NULL.
+func (s SQLiteStore) columnValue(c sqliteColumn, raw json.RawMessage) (any, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	switch c.kind {
+	case sqliteText:
+		var v string
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case sqliteInteger:
+		var v int64
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case sqliteBool:
+		var v bool
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	default:
+		return string(raw), nil
+	}
+}
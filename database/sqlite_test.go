@@ -0,0 +1,214 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSQLiteStore[PMCVersion](ctx, openTestSQLite(t), "pmc", PMCPartitionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := PMCVersion{
+		Id:           "2024-01-02-x86_64-0.14.0",
+		Ver:          "0.14.0",
+		Arch:         "x86_64",
+		Format:       "rpm",
+		TodayCount:   3,
+		TotalCount:   1 << 40,
+		Date:         "2024-01-02",
+		TodayClients: 2,
+		Classes:      []PMCClass{{Class: "ci", TodayCount: 1, TodayClients: 1}},
+		Distros:      []PMCDistro{{Distro: "rhel", Release: "9", TodayCount: 3, TodayClients: 2}},
+	}
+	if err := CreateOrUpdateItem(ctx, store, item.Arch, item); err != nil {
+		t.Fatal(err)
+	}
+	item.TodayCount = 4
+	if err := CreateOrUpdateItem(ctx, store, item.Arch, item); err != nil {
+		t.Fatal(err)
+	}
+
+	var got PMCVersion
+	if err := ReadItem(ctx, store, item.Arch, item.Id, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, item) {
+		t.Fatalf("read %+v, want %+v", got, item)
+	}
+
+	items, err := QueryItem(ctx, store, item.Arch, item.Date, PMCVersion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !reflect.DeepEqual(items[0], item) {
+		t.Fatalf("query %+v, want [%+v]", items, item)
+	}
+
+	var columns int
+	if err := store.db.QueryRow(`SELECT count(*) FROM pragma_table_info('pmc') WHERE name IN ('TodayCount', 'Classes')`).Scan(&columns); err != nil {
+		t.Fatal(err)
+	}
+	if columns != 2 {
+		t.Fatalf("table pmc has %d of the columns TodayCount and Classes", columns)
+	}
+}
+
+func TestSQLiteStoreTime(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSQLiteStore[GithubVersion](ctx, openTestSQLite(t), "github", GithubPartitionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := GithubVersion{Id: "a", Repo: "Azure/aztfexport", Ver: "v0.14.0", OsType: "linux", PublishDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), CountDate: "2024-01-02"}
+	if err := CreateOrUpdateItem(ctx, store, item.OsType, item); err != nil {
+		t.Fatal(err)
+	}
+	var got GithubVersion
+	if err := ReadItem(ctx, store, item.OsType, item.Id, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, item) {
+		t.Fatalf("read %+v, want %+v", got, item)
+	}
+}
+
+func TestSQLiteStoreErrors(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSQLiteStore[HomebrewVersion](ctx, openTestSQLite(t), "homebrew", HomebrewPartitionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Read(ctx, "darwin", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("read of a missing item: %v, want ErrNotFound", err)
+	}
+	if err := CreateOrUpdateItem(ctx, store, "linux", HomebrewVersion{Id: "a", OsType: "darwin", CountDate: "2024-01-02"}); err == nil {
+		t.Fatal("upsert into another partition succeeded")
+	}
+	if _, err := NewSQLiteStore[HomebrewVersion](ctx, store.db, "homebrew", "Arch"); err == nil {
+		t.Fatal("partition key of no field is accepted")
+	}
+	if _, err := NewSQLiteStore[HomebrewVersion](ctx, store.db, "drop table", HomebrewPartitionKey); err == nil {
+		t.Fatal("invalid table name is accepted")
+	}
+}
+
+func TestSQLiteStoreAddColumns(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSQLite(t)
+
+	store, err := NewSQLiteStore[HomebrewVersion](ctx, db, "homebrew", HomebrewPartitionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := HomebrewVersion{Id: "a", OsType: "darwin", TodayCount: 5, CountDate: "2024-01-02"}
+	if err := CreateOrUpdateItem(ctx, store, "darwin", want); err != nil {
+		t.Fatal(err)
+	}
+
+	// the table of a former version, without the fields added later
+	if _, err := db.Exec(`ALTER TABLE homebrew DROP COLUMN "ApiFailure"`); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = NewSQLiteStore[HomebrewVersion](ctx, db, "homebrew", HomebrewPartitionKey); err != nil {
+		t.Fatal(err)
+	}
+	var got HomebrewVersion
+	if err := ReadItem(ctx, store, "darwin", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("read %+v, want %+v", got, want)
+	}
+
+	want.ApiFailure = true
+	if err := CreateOrUpdateItem(ctx, store, "darwin", want); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReadItem(ctx, store, "darwin", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("read %+v, want %+v", got, want)
+	}
+}
+
+func TestReadOnlySQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "counts.db")
+	db, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := NewSQLiteStore[HomebrewVersion](ctx, db, "homebrew", HomebrewPartitionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := HomebrewVersion{Id: "a", OsType: "darwin", TodayCount: 5, CountDate: "2024-01-02"}
+	if err := CreateOrUpdateItem(ctx, store, "darwin", item); err != nil {
+		t.Fatal(err)
+	}
+	// the table of a former version, without the fields added later
+	if _, err := db.Exec(`ALTER TABLE homebrew DROP COLUMN "ApiFailure"`); err != nil {
+		t.Fatal(err)
+	}
+
+	roDB, err := OpenSQLiteReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer roDB.Close()
+	if _, err := NewReadOnlySQLiteStore[PMCVersion](ctx, roDB, "pmc", PMCPartitionKey); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("store of a missing table: %v, want ErrNotFound", err)
+	}
+	roStore, err := NewReadOnlySQLiteStore[HomebrewVersion](ctx, roDB, "homebrew", HomebrewPartitionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got HomebrewVersion
+	if err := ReadItem(ctx, roStore, "darwin", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != item {
+		t.Fatalf("read %+v, want %+v", got, item)
+	}
+	if err := CreateOrUpdateItem(ctx, roStore, "darwin", item); err == nil {
+		t.Fatal("upsert into a read only store succeeded")
+	}
+	if _, err := roDB.Exec(`CREATE TABLE pmc (id TEXT)`); err == nil {
+		t.Fatal("read only database is writable")
+	}
+
+	var columns int
+	if err := db.QueryRow(`SELECT count(*) FROM pragma_table_info('homebrew') WHERE name = 'ApiFailure'`).Scan(&columns); err != nil {
+		t.Fatal(err)
+	}
+	if columns != 0 {
+		t.Fatal("read only store added the missing column")
+	}
+
+	if _, err := OpenSQLiteReadOnly(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Fatal("missing database file is opened")
+	}
+}
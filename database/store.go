@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The partition key paths of the containers.
+const (
+	HomebrewPartitionKey = "OsType"
+	GithubPartitionKey   = "OsType"
+	PMCPartitionKey      = "Arch"
+
+	GithubCachePartitionKey = "Repo"
+)
+
+// Store is a collection of JSON items grouped by partition key, e.g. a Cosmos DB container.
+// Every item is expected to carry an "id" and a "Date" field.
+type Store interface {
+	// Upsert creates or replaces a single item in the given partition.
+	Upsert(ctx context.Context, pk string, item []byte) error
+	// BatchUpsert creates or replaces a set of items in the given partition.
+	// The items might be written in several chunks, each of them is written entirely or not at all.
+	// The error is a BatchError when any chunk fails.
+	BatchUpsert(ctx context.Context, pk string, items [][]byte) (BatchSummary, error)
+	// Read returns the item with the given id in the given partition.
+	Read(ctx context.Context, pk, id string) ([]byte, error)
+	// Query returns all items in the given partition counted on the given date.
+	Query(ctx context.Context, pk, date string) ([][]byte, error)
+}
+
+// ChunkResult is the result of writing a chunk of a batch.
+type ChunkResult struct {
+	Ids   []string
+	Bytes int
+	Err   error
+}
+
+func newChunkResult(items [][]byte, err error) ChunkResult {
+	result := ChunkResult{Err: err}
+	for _, item := range items {
+		key, _ := parseItemKey(item)
+		result.Ids = append(result.Ids, key.Id)
+		result.Bytes += len(item)
+	}
+	return result
+}
+
+// BatchSummary are the results of the chunks of a batch, in the order of the items.
+type BatchSummary []ChunkResult
+
+func (s BatchSummary) String() string {
+	var b strings.Builder
+	items, failed := 0, 0
+	for i, chunk := range s {
+		items += len(chunk.Ids)
+		status := "ok"
+		if chunk.Err != nil {
+			failed++
+			status = fmt.Sprintf("failed: %v", chunk.Err)
+		}
+		fmt.Fprintf(&b, "\n\tchunk %d: %d items, %d bytes, %s", i+1, len(chunk.Ids), chunk.Bytes, status)
+	}
+	return fmt.Sprintf("%d items in %d chunks, %d chunks failed", items, len(s), failed) + b.String()
+}
+
+// err returns a BatchError of the items of the failed chunks, or nil if all of them succeeded.
+func (s BatchSummary) err(pk string) error {
+	batchErr := &BatchError{Partition: pk}
+	for _, chunk := range s {
+		batchErr.Total += len(chunk.Ids)
+		if chunk.Err == nil {
+			continue
+		}
+		for _, id := range chunk.Ids {
+			batchErr.Failed = append(batchErr.Failed, ItemError{Id: id, Err: chunk.Err})
+		}
+	}
+	if len(batchErr.Failed) == 0 {
+		return nil
+	}
+	return batchErr
+}
+
+func CreateOrUpdateItem[T DBItem](ctx context.Context, store Store, pk string, item T) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	return store.Upsert(ctx, pk, b)
+}
+
+func QueryItem[T DBItem](ctx context.Context, store Store, pk, date string, response T) (resp []T, err error) {
+	items, errs := store.Query(ctx, pk, date)
+
+	for _, item := range items {
+		// start from the given response every time, so fields don't leak between items.
+		v := response
+		err := json.Unmarshal(item, &v)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		resp = append(resp, v)
+	}
+	return resp, errs
+}
+
+func BatchUpsert[T DBItem](ctx context.Context, store Store, pk string, items []T) (BatchSummary, error) {
+	batch := make([][]byte, 0, len(items))
+	for _, item := range items {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+
+		batch = append(batch, b)
+	}
+
+	return store.BatchUpsert(ctx, pk, batch)
+}
+
+func ReadItem[T DBItem](ctx context.Context, store Store, pk, itemId string, response *T) error {
+	b, err := store.Read(ctx, pk, itemId)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, response)
+}
@@ -2,35 +2,265 @@ package datasource
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
+	"aztfy-download-counter/retry"
 	"github.com/google/go-github/v50/github"
 )
 
 const GithubPerPage = 20
+const GithubApiUrl = "https://api.github.com/"
 const RepoOwner = "Azure"
 const RepoName = "aztfexport"
 
-func FetchGitHubDownloadCount(ctx context.Context) ([]*github.RepositoryRelease, error) {
-	client := github.NewClient(nil)
+const (
+	// githubMaxRateLimitWait is the longest time to wait for the rate limit to reset, the primary rate limit resets hourly.
+	githubMaxRateLimitWait = time.Hour + time.Minute
+	// githubSecondaryRetries is how many times a request is retried after hitting the secondary rate limit.
+	githubSecondaryRetries = 3
+	// githubSecondaryBackoff is the first wait of the secondary rate limit when GitHub doesn't tell how long to wait.
+	githubSecondaryBackoff = time.Minute
+)
+
+// GithubClient is a GitHub API client which waits for the rate limit to reset instead of failing.
+// It's safe to share a client between workers, they share the same rate limit as well.
+type GithubClient struct {
+	client *github.Client
+	logger *log.Logger
+	retry  retry.Policy
+
+	mu   *sync.Mutex
+	rate github.Rate
+}
+
+// NewGithubClient returns a client of the API at baseURL, authenticated with token, or an anonymous one when token is empty.
+// httpClient sends the requests, http.DefaultClient is used when it's nil. The transient failures are retried by policy.
+func NewGithubClient(baseURL string, httpClient *http.Client, token string, policy retry.Policy, logger *log.Logger) (*GithubClient, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if len(token) != 0 {
+		authClient := *httpClient
+		authClient.Transport = &githubTokenTransport{token: token, base: httpClient.Transport}
+		httpClient = &authClient
+	}
+
+	client := github.NewClient(httpClient)
+	// the client requires the trailing slash of the base url.
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API url %q: %+v", baseURL, err)
+	}
+	client.BaseURL = u
+
+	if policy.Logger == nil {
+		policy.Logger = logger
+	}
+
+	return &GithubClient{
+		client: client,
+		logger: logger,
+		retry:  policy,
+		mu:     &sync.Mutex{},
+	}, nil
+}
+
+// Rate returns the rate limit state of the last response.
+func (c *GithubClient) Rate() github.Rate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rate
+}
+
+// GithubCachedPage is a page of the release list with the validators of its response.
+type GithubCachedPage struct {
+	ETag         string
+	LastModified string
+	Releases     []*github.RepositoryRelease
+}
+
+// GithubCache persists the pages of the release lists between runs, so that they can be requested conditionally.
+type GithubCache interface {
+	// Get returns false when the page is not cached.
+	Get(ctx context.Context, owner, repo string, page int) (GithubCachedPage, bool, error)
+	Put(ctx context.Context, owner, repo string, page int, data GithubCachedPage) error
+}
+
+// FetchDownloadCount lists all the releases of a repository.
+// The pages in cache are requested conditionally, and reused when they are not modified. cache is optional.
+func (c *GithubClient) FetchDownloadCount(ctx context.Context, owner, repo string, cache GithubCache) ([]*github.RepositoryRelease, error) {
 	result := make([]*github.RepositoryRelease, 0)
 
-	itemCount := 0
-	for i := 1; itemCount < GithubPerPage; i++ {
-		itemCount = 0
+	// the last page is the first one which is not full.
+	for page := 1; ; page++ {
+		releases, err := c.fetchReleasePage(ctx, owner, repo, page, cache)
+		if err != nil {
+			return nil, err
+		}
 
-		opt := &github.ListOptions{
-			Page:    i,
-			PerPage: GithubPerPage,
+		result = append(result, releases...)
+		if len(releases) < GithubPerPage {
+			return result, nil
 		}
+	}
+}
 
-		releases, _, err := client.Repositories.ListReleases(ctx, RepoOwner, RepoName, opt)
+func (c *GithubClient) fetchReleasePage(ctx context.Context, owner, repo string, page int, cache GithubCache) ([]*github.RepositoryRelease, error) {
+	var cached GithubCachedPage
+	var ok bool
+	if cache != nil {
+		var err error
+		if cached, ok, err = cache.Get(ctx, owner, repo, page); err != nil {
+			c.logger.Printf("read cached page %d of %s/%s failed, request it unconditionally: %+v", page, owner, repo, err)
+			ok = false
+		}
+	}
+
+	var releases []*github.RepositoryRelease
+	var resp *github.Response
+	err := c.do(ctx, func(ctx context.Context) (*github.Response, error) {
+		req, err := c.client.NewRequest(http.MethodGet, fmt.Sprintf("repos/%v/%v/releases?page=%d&per_page=%d", owner, repo, page, GithubPerPage), nil)
 		if err != nil {
 			return nil, err
 		}
+		if ok && len(cached.ETag) != 0 {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if ok && len(cached.LastModified) != 0 {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
 
-		result = append(result, releases...)
-		itemCount = len(releases)
+		releases = nil
+		resp, err = c.client.Do(ctx, req, &releases)
+		if resp != nil && resp.StatusCode == http.StatusNotModified {
+			return resp, nil
+		}
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return cached.Releases, nil
+	}
+
+	if cache != nil {
+		data := GithubCachedPage{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Releases:     releases,
+		}
+		if len(data.ETag) != 0 || len(data.LastModified) != 0 {
+			if err := cache.Put(ctx, owner, repo, page, data); err != nil {
+				c.logger.Printf("cache page %d of %s/%s failed: %+v", page, owner, repo, err)
+			}
+		}
+	}
+
+	return releases, nil
+}
+
+// Check verifies the repository can be read, and returns the remaining requests of the rate limit.
+func (c *GithubClient) Check(ctx context.Context, owner, repo string) (int, error) {
+	err := c.do(ctx, func(ctx context.Context) (*github.Response, error) {
+		_, resp, err := c.client.Repositories.Get(ctx, owner, repo)
+		return resp, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return c.Rate().Remaining, nil
+}
+
+// FetchActionsRanges returns the IP ranges of the hosted runners of GitHub Actions in the CIDR notation,
+// both IPv4 and IPv6, as published by the meta API.
+func (c *GithubClient) FetchActionsRanges(ctx context.Context) ([]string, error) {
+	var meta *github.APIMeta
+	err := c.do(ctx, func(ctx context.Context) (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		meta, resp, err = c.client.APIMeta(ctx)
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
 	}
+	if len(meta.Actions) == 0 {
+		return nil, errors.New("no ranges of GitHub Actions in the meta API")
+	}
+	return meta.Actions, nil
+}
+
+// do calls the API, and waits for the rate limit when it's exhausted.
+// The primary rate limit is waited till it resets, the secondary one is retried with backoff.
+// The other transient failures are retried by the retry policy, whose timeout limits every call but not the waits for the rate limit.
+func (c *GithubClient) do(ctx context.Context, call func(ctx context.Context) (*github.Response, error)) error {
+	for attempt := 0; ; attempt++ {
+		err := c.retry.Do(ctx, retryable, func(ctx context.Context) error {
+			resp, err := call(ctx)
+			if resp != nil && resp.Rate.Limit != 0 {
+				c.mu.Lock()
+				c.rate = resp.Rate
+				c.mu.Unlock()
+			}
+			return err
+		})
+		if err == nil {
+			return nil
+		}
+
+		var wait time.Duration
+		var rateErr *github.RateLimitError
+		var abuseErr *github.AbuseRateLimitError
+		switch {
+		case errors.As(err, &rateErr):
+			wait = time.Until(rateErr.Rate.Reset.Time)
+			if wait > githubMaxRateLimitWait {
+				return fmt.Errorf("rate limit resets in %v, not waiting for it: %w", wait, err)
+			}
+			c.logger.Printf("rate limit exhausted (%d/%d), wait %v till it resets", rateErr.Rate.Remaining, rateErr.Rate.Limit, wait)
+		case errors.As(err, &abuseErr):
+			if attempt >= githubSecondaryRetries {
+				return err
+			}
+			wait = githubSecondaryBackoff << attempt
+			if abuseErr.RetryAfter != nil {
+				wait = *abuseErr.RetryAfter
+			}
+			c.logger.Printf("secondary rate limit hit, wait %v before retrying", wait)
+		default:
+			return err
+		}
 
-	return result, nil
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+type githubTokenTransport struct {
+	token string
+	// base sends the requests, http.DefaultTransport is used when it's nil.
+	base http.RoundTripper
+}
+
+func (t *githubTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	if t.base == nil {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
 }
@@ -0,0 +1,35 @@
+package datasource
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"aztfy-download-counter/retry"
+)
+
+func TestGithubFetchActionsRanges(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/meta" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"hooks": ["192.30.252.0/22"], "actions": ["4.148.0.0/16", "2a01:111:f403::/48"]}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewGithubClient(srv.URL, srv.Client(), "", retry.Policy{MaxAttempts: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges, err := client.FetchActionsRanges(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"4.148.0.0/16", "2a01:111:f403::/48"}; !reflect.DeepEqual(ranges, want) {
+		t.Errorf("ranges %v, want %v", ranges, want)
+	}
+}
@@ -1,12 +1,20 @@
 package datasource
 
 import (
+	"context"
 	"encoding/json"
-	"io"
+	"fmt"
+	"log"
+	"mime"
 	"net/http"
+	"net/url"
+
+	"aztfy-download-counter/retry"
 )
 
-const HomeBrewApiUri = "https://formulae.brew.sh/api/formula/aztfexport.json"
+// HomeBrewApiUrl is the base URL of the formula API, the analytics of a formula are at <base>/<formula>.json.
+const HomeBrewApiUrl = "https://formulae.brew.sh/api/formula/"
+const HomeBrewFormula = "aztfexport"
 
 type BrewJson struct {
 	Analytics struct {
@@ -24,28 +32,100 @@ type Install struct {
 	OneYear    InstallCount `json:"365d"`
 }
 
-type InstallCount struct {
-	Aztfy int `json:"aztfexport"`
+// InstallCount is the install count keyed by formula name.
+type InstallCount map[string]int
+
+// ContentTypeError is returned when the API responds with something other than JSON, e.g. an error page.
+type ContentTypeError struct {
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("unexpected content type %q", e.ContentType)
+}
+
+// DecodeError is returned when the response is not the analytics of a formula.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode the analytics failed: %v", e.Err)
 }
 
-func FetchHomeBrewDownloadCount() (*BrewJson, error) {
-	cli := http.Client{}
-	resp, err := cli.Get(HomeBrewApiUri)
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// HomebrewClient gets the analytics of a formula from the Homebrew API.
+type HomebrewClient struct {
+	apiUrl     string
+	httpClient *http.Client
+	retry      retry.Policy
+}
+
+// NewHomebrewClient returns a client of a formula of the formula API at baseURL, e.g. HomeBrewApiUrl.
+// httpClient sends the requests, http.DefaultClient is used when it's nil. The transient failures are retried by policy.
+func NewHomebrewClient(baseURL, formula string, httpClient *http.Client, policy retry.Policy, logger *log.Logger) (*HomebrewClient, error) {
+	apiUrl, err := url.JoinPath(baseURL, url.PathEscape(formula)+".json")
+	if err != nil {
+		return nil, fmt.Errorf("invalid homebrew API URL %q: %+v", baseURL, err)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if policy.Logger == nil {
+		policy.Logger = logger
+	}
+	return &HomebrewClient{
+		apiUrl:     apiUrl,
+		httpClient: httpClient,
+		retry:      policy,
+	}, nil
+}
+
+// ApiUrl returns the URL of the analytics of the formula.
+func (c *HomebrewClient) ApiUrl() string {
+	return c.apiUrl
+}
+
+// FetchDownloadCount gets the analytics of the formula.
+func (c *HomebrewClient) FetchDownloadCount(ctx context.Context) (*BrewJson, error) {
+	var brewJson *BrewJson
+	err := c.retry.Do(ctx, retryable, func(ctx context.Context) error {
+		var err error
+		brewJson, err = c.fetchDownloadCount(ctx)
+		return err
+	})
+	return brewJson, err
+}
+
+func (c *HomebrewClient) fetchDownloadCount(ctx context.Context) (*BrewJson, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.apiUrl, nil)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Accept", "application/json")
 
-	defer resp.Body.Close()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
 
-	var brewJson BrewJson
+	defer resp.Body.Close()
 
-	decErr := json.NewDecoder(resp.Body).Decode(&brewJson)
-	if decErr == io.EOF {
-		decErr = nil
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp)
 	}
-	if decErr != nil {
-		err = decErr
+	// an error page is not taken as the analytics without any install.
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		return nil, &ContentTypeError{ContentType: resp.Header.Get("Content-Type")}
+	}
+
+	var brewJson BrewJson
+	if err := json.NewDecoder(resp.Body).Decode(&brewJson); err != nil {
+		return nil, &DecodeError{Err: err}
 	}
 
-	return &brewJson, err
+	return &brewJson, nil
 }
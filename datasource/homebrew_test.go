@@ -0,0 +1,113 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"aztfy-download-counter/retry"
+)
+
+const brewJsonBody = `{
+  "name": "aztfexport",
+  "analytics": {"install": {"30d": {"aztfexport": 30}, "90d": {"aztfexport": 90}, "365d": {"aztfexport": 365}}},
+  "analytics-linux": {"install": {"30d": {"aztfexport": 3}, "90d": {"aztfexport": 9}, "365d": {"aztfexport": 36}}}
+}`
+
+func newTestHomebrewClient(t *testing.T, attempts int, handler http.HandlerFunc) (*HomebrewClient, *int) {
+	t.Helper()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/api/formula/aztfexport.json" {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := NewHomebrewClient(srv.URL+"/api/formula/", HomeBrewFormula, srv.Client(), retry.Policy{MaxAttempts: attempts}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &calls
+}
+
+func TestHomebrewFetchDownloadCount(t *testing.T) {
+	client, _ := newTestHomebrewClient(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(brewJsonBody))
+	})
+
+	brewJson, err := client.FetchDownloadCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := brewJson.Analytics.Install.ThirtyDays[HomeBrewFormula]; got != 30 {
+		t.Errorf("30d installs on macOS: %d, want 30", got)
+	}
+	if got := brewJson.AnalyticsLinux.Install.OneYear[HomeBrewFormula]; got != 36 {
+		t.Errorf("365d installs on Linux: %d, want 36", got)
+	}
+}
+
+func TestHomebrewFetchDownloadCountNotFound(t *testing.T) {
+	client, calls := newTestHomebrewClient(t, 3, func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
+	_, err := client.FetchDownloadCount(context.Background())
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("error %v, want status 404", err)
+	}
+	if *calls != 1 {
+		t.Errorf("404 is called %d times, it's not transient", *calls)
+	}
+}
+
+func TestHomebrewFetchDownloadCountServerError(t *testing.T) {
+	client, calls := newTestHomebrewClient(t, 2, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("<html><body>Internal Server Error</body></html>"))
+	})
+
+	_, err := client.FetchDownloadCount(context.Background())
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("error %v, want status 500", err)
+	}
+	if *calls != 2 {
+		t.Errorf("500 is called %d times, want it retried", *calls)
+	}
+}
+
+func TestHomebrewFetchDownloadCountContentType(t *testing.T) {
+	client, _ := newTestHomebrewClient(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><body>maintenance</body></html>"))
+	})
+
+	_, err := client.FetchDownloadCount(context.Background())
+	var contentTypeErr *ContentTypeError
+	if !errors.As(err, &contentTypeErr) {
+		t.Fatalf("error %v, want a content type error", err)
+	}
+}
+
+func TestHomebrewFetchDownloadCountTruncated(t *testing.T) {
+	client, _ := newTestHomebrewClient(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(brewJsonBody[:len(brewJsonBody)/2]))
+	})
+
+	_, err := client.FetchDownloadCount(context.Background())
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("error %v, want a decode error", err)
+	}
+}
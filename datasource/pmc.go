@@ -5,21 +5,56 @@ import (
 	"fmt"
 	"time"
 
+	"aztfy-download-counter/job/pmcutils"
+	"aztfy-download-counter/retry"
 	"github.com/Azure/azure-kusto-go/kusto"
 	"github.com/Azure/azure-kusto-go/kusto/data/errors"
 	"github.com/Azure/azure-kusto-go/kusto/data/table"
 	"github.com/Azure/azure-kusto-go/kusto/data/types"
+	"github.com/Azure/azure-kusto-go/kusto/unsafe"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 )
 
 const PMCDBName = "Repos"
 
-type KustoResponse struct {
-	Path string `kusto:"path"`
+// PMCSource queries the access logs of the packages in PMC.
+type PMCSource interface {
+	// QueryDailyCounts returns the download counts of the packages per day, from the date from to to.
+	// The count of a date is the downloads in the day before it, as the collection of a date runs at its start.
+	QueryDailyCounts(ctx context.Context, from, to time.Time) ([]PMCCount, error)
+	Close() error
 }
 
-type TotalCountResponse struct {
-	Count int64 `kusto:"Count"`
+// The breakdowns of the PMC counts, the downloads of a version-arch in a day are counted in total, by the classes of
+// the clients and by the distro releases, separately. The distinct clients of each of them are estimated as a whole,
+// instead of being summed up, as a client could download from several repositories.
+const (
+	PMCBreakdownTotal  = "total"
+	PMCBreakdownClass  = "class"
+	PMCBreakdownDistro = "distro"
+	// PMCBreakdownPath is the downloads of a path which is not a package, e.g. the metadata of a repository.
+	PMCBreakdownPath = "path"
+)
+
+// PMCCount is the download count of a package in a day, in total or broken down by Class or Distro and Release.
+// The packages are parsed from the paths of the downloads by the source, see pmcutils.ParsePath. The classes are of
+// the clients, e.g. CI, see PMCClassRule. The fields not of the Breakdown are empty.
+// Clients estimates the distinct clients of the downloads by their IPs and user agents, it's 0 when the log doesn't have them.
+type PMCCount struct {
+	Day       time.Time `kusto:"Day"`
+	Breakdown string    `kusto:"Breakdown"`
+	// Format, Version and Arch are the package downloaded, they are empty when the path is not a package.
+	Format  string `kusto:"Format"`
+	Version string `kusto:"Version"`
+	Arch    string `kusto:"Arch"`
+	Class   string `kusto:"Class"`
+	// Distro and Release are the repository of the package, they are empty when the layout is unknown.
+	Distro  string `kusto:"Distro"`
+	Release string `kusto:"Release"`
+	// Path is the path of the downloads which are not packages.
+	Path    string `kusto:"Path"`
+	Count   int64  `kusto:"Count"`
+	Clients int64  `kusto:"Clients"`
 }
 
 func AuthKusto(endpoint string) (client *kusto.Client, err error) {
@@ -33,174 +68,111 @@ func AuthKusto(endpoint string) (client *kusto.Client, err error) {
 	return kusto.New(kustoConnectionString)
 }
 
-func queryCmdAztfy(date time.Time) kusto.Stmt {
-	defMap := map[string]kusto.ParamType{
-		"targetDate": {Type: types.DateTime},
-	}
-	paramMap := map[string]interface{}{
-		"targetDate": date,
-	}
-
-	return kusto.NewStmt(`HttpAccessLog 
-| where path contains "aztfy"
-    and path contains "rpm"
-    and method == "GET"
-    and code == "200"
-    and (PreciseTimeStamp >= datetime_add('day',-1,targetDate) and PreciseTimeStamp <= targetDate)`).MustDefinitions(kusto.NewDefinitions().Must(defMap)).MustParameters(kusto.NewParameters().Must(paramMap))
+// KustoPMCSource is a PMCSource querying the HttpAccessLog table of a Kusto database.
+type KustoPMCSource struct {
+	client   *kusto.Client
+	dbName   string
+	packages []string
+	rules    []PMCClassRule
+	retry    retry.Policy
 }
 
-func queryCmdAztfexport(date time.Time) kusto.Stmt {
-	defMap := map[string]kusto.ParamType{
-		"targetDate": {Type: types.DateTime},
-	}
-	paramMap := map[string]interface{}{
-		"targetDate": date,
-	}
+var _ PMCSource = &KustoPMCSource{}
 
-	return kusto.NewStmt(`HttpAccessLog 
-| where path contains "aztfexport"
-    and path contains "rpm"
-    and method == "GET"
-    and code == "200"
-    and (PreciseTimeStamp >= datetime_add('day',-1,targetDate) and PreciseTimeStamp <= targetDate)`).MustDefinitions(kusto.NewDefinitions().Must(defMap)).MustParameters(kusto.NewParameters().Must(paramMap))
-}
-
-func queryCmdForTotalCountAztfexport(startDate, endDate time.Time, arch, version string) kusto.Stmt {
-	defMap := map[string]kusto.ParamType{
-		"startDate":     {Type: types.DateTime},
-		"endDate":       {Type: types.DateTime},
-		"targetArch":    {Type: types.String},
-		"targetVersion": {Type: types.String},
-	}
-	paramMap := map[string]interface{}{
-		"startDate":     startDate,
-		"endDate":       endDate,
-		"targetArch":    arch,
-		"targetVersion": version,
+// NewKustoPMCSource returns a source of the packages in the database dbName of the cluster at endpoint.
+// The downloads are classified by rules, and the transient failures are retried by policy.
+func NewKustoPMCSource(endpoint, dbName string, packages []string, rules []PMCClassRule, policy retry.Policy) (*KustoPMCSource, error) {
+	client, err := AuthKusto(endpoint)
+	if err != nil {
+		return nil, err
 	}
-
-	return kusto.NewStmt(`HttpAccessLog 
-| where path contains "aztfexport"
-	and path contains targetArch
-	and path contains targetVersion
-    and path contains "rpm"
-    and method == "GET"
-    and code == "200"
-    and (PreciseTimeStamp >= startDate and PreciseTimeStamp <= endDate)
-| count `).MustDefinitions(kusto.NewDefinitions().Must(defMap)).MustParameters(kusto.NewParameters().Must(paramMap))
+	return &KustoPMCSource{
+		client:   client,
+		dbName:   dbName,
+		packages: packages,
+		rules:    rules,
+		retry:    policy,
+	}, nil
 }
 
-func queryCmdForTotalCountAztfy(startDate, endDate time.Time, arch, version string) kusto.Stmt {
-	defMap := map[string]kusto.ParamType{
-		"startDate":     {Type: types.DateTime},
-		"endDate":       {Type: types.DateTime},
-		"targetArch":    {Type: types.String},
-		"targetVersion": {Type: types.String},
-	}
-	paramMap := map[string]interface{}{
-		"startDate":     startDate,
-		"endDate":       endDate,
-		"targetArch":    arch,
-		"targetVersion": version,
-	}
-
-	return kusto.NewStmt(`HttpAccessLog 
-| where path contains "aztfy"
-	and path contains targetArch
-	and path contains targetVersion
-    and path contains "rpm"
-    and method == "GET"
-    and code == "200"
-    and (PreciseTimeStamp >= startDate and PreciseTimeStamp <= endDate)
-| count `).MustDefinitions(kusto.NewDefinitions().Must(defMap)).MustParameters(kusto.NewParameters().Must(paramMap))
+func (s *KustoPMCSource) Close() error {
+	return s.client.Close()
 }
 
-func QueryTotalCount(ctx context.Context, client *kusto.Client, startDate, endDate time.Time, version, arch string) (int64, error) {
-	aztfy, err := doCntQuery(ctx, client, queryCmdForTotalCountAztfexport(startDate, endDate, arch, version))
+// CheckKusto verifies the access log table can be queried.
+func CheckKusto(ctx context.Context, client *kusto.Client, dbName string) error {
+	iter, err := client.Query(ctx, dbName, kusto.NewStmt("HttpAccessLog | take 1"))
 	if err != nil {
-		return -1, err
+		return err
 	}
+	defer iter.Stop()
 
-	aztfexport, err := doCntQuery(ctx, client, queryCmdForTotalCountAztfy(startDate, endDate, arch, version))
-	if err != nil {
-		return -1, err
-	}
-
-	var ret int64 = 0
-	if len(aztfy) > 0 {
-		ret += aztfy[0].Count
-	}
-	if len(aztfexport) > 0 {
-		ret += aztfexport[0].Count
-	}
-
-	return ret, nil
+	return iter.DoOnRowOrError(func(row *table.Row, err *errors.Error) error {
+		if err != nil {
+			return err
+		}
+		return nil
+	})
 }
 
-func doCntQuery(ctx context.Context, client *kusto.Client, cmd kusto.Stmt) ([]TotalCountResponse, error) {
-	var recs []TotalCountResponse
-
-	iter, err := client.Query(ctx, PMCDBName, cmd)
-	if err != nil {
-		return nil, err
-	}
-
-	err = iter.DoOnRowOrError(
-		func(row *table.Row, err *errors.Error) error {
-			if err != nil {
-				return err
-			}
-			rec := TotalCountResponse{}
-			if err := row.ToStruct(&rec); err != nil {
-				return err
-			}
-			recs = append(recs, rec)
-
-			return nil
-		},
-	)
-
-	return recs, err
+func queryCmdDailyCounts(packages []string, rules []PMCClassRule, from, to time.Time) kusto.Stmt {
+	classExpr, defMap, paramMap := kustoClassExpr(rules)
+	defMap["targetPackages"] = kusto.ParamType{Type: types.Dynamic}
+	defMap["startDate"] = kusto.ParamType{Type: types.DateTime}
+	defMap["endDate"] = kusto.ParamType{Type: types.DateTime}
+	paramMap["targetPackages"] = packages
+	paramMap["startDate"] = from.AddDate(0, 0, -1)
+	paramMap["endDate"] = to
+
+	// a download at the midnight of a date is counted in the date, so the days are shifted by a tick.
+	// the clients are told apart by the hash of their IP and user agent, the columns might not be in the log.
+	// the class expression only refers to the parameters, the rules are passed as their values.
+	// the packages are parsed from the paths in the query, only the paths which are not packages are returned as they are.
+	// the clients are sketched by hll in the finest groups, and merged into the estimate of every breakdown.
+	return kusto.NewStmt(`let downloads = materialize(HttpAccessLog
+| where PreciseTimeStamp > startDate and PreciseTimeStamp <= endDate
+| where path has_any (targetPackages)
+    and (path contains "rpm" or path contains ".deb")
+    and method == "GET"
+    and code == "200"
+| extend ClientIp = tostring(column_ifexists("clientIp", "")), UserAgent = tostring(column_ifexists("userAgent", ""))
+| extend Client = iff(isempty(ClientIp) and isempty(UserAgent), long(null), hash(strcat(ClientIp, "|", UserAgent)))
+| extend Class = `, kusto.UnsafeStmt(unsafe.Stmt{Add: true, SuppressWarning: true})).UnsafeAdd(classExpr).UnsafeAdd(pmcutils.KustoParsePath("path")).Add(`
+| extend Path = iff(isempty(Format), path, "")
+| summarize Count = count(), Clients = hllif(Client, isnotnull(Client)) by Format, Version, Arch, Distro, Release, Class, Path, Day = startofday(PreciseTimeStamp - 1tick) + 1d);
+let packages = downloads | where isnotempty(Format);
+union
+    (packages | summarize Count = sum(Count), Clients = hll_merge(Clients) by Format, Version, Arch, Day | extend Breakdown = "total"),
+    (packages | summarize Count = sum(Count), Clients = hll_merge(Clients) by Format, Version, Arch, Class, Day | extend Breakdown = "class"),
+    (packages | summarize Count = sum(Count), Clients = hll_merge(Clients) by Format, Version, Arch, Distro, Release, Day | extend Breakdown = "distro"),
+    (downloads | where isempty(Format) | summarize Count = sum(Count) by Path, Day | extend Breakdown = "path")
+| extend Clients = iff(Breakdown == "path", long(0), dcount_hll(Clients))
+| project Day, Breakdown, Format, Version, Arch, Class, Distro, Release, Path, Count, Clients`).MustDefinitions(kusto.NewDefinitions().Must(defMap)).MustParameters(kusto.NewParameters().Must(paramMap))
 }
 
-func QueryForPMC(ctx context.Context, client *kusto.Client, date time.Time) ([]KustoResponse, error) {
-	var recs []KustoResponse
-
-	aztfy, err := doPMCQuery(ctx, client, queryCmdAztfy(date))
-	if err != nil {
-		return nil, err
-	}
-	recs = append(recs, aztfy...)
-
-	aztfexport, err := doPMCQuery(ctx, client, queryCmdAztfexport(date))
-	if err != nil {
-		return nil, err
-	}
-	recs = append(recs, aztfexport...)
-
+func (s *KustoPMCSource) QueryDailyCounts(ctx context.Context, from, to time.Time) ([]PMCCount, error) {
+	var recs []PMCCount
+	err := s.retry.Do(ctx, retryable, func(ctx context.Context) error {
+		recs = nil
+		iter, err := s.client.Query(ctx, s.dbName, queryCmdDailyCounts(s.packages, s.rules, from, to))
+		if err != nil {
+			return err
+		}
+		defer iter.Stop()
+
+		return iter.DoOnRowOrError(
+			func(row *table.Row, err *errors.Error) error {
+				if err != nil {
+					return err
+				}
+				rec := PMCCount{}
+				if err := row.ToStruct(&rec); err != nil {
+					return err
+				}
+				recs = append(recs, rec)
+
+				return nil
+			})
+	})
 	return recs, err
 }
-
-func doPMCQuery(ctx context.Context, client *kusto.Client, cmd kusto.Stmt) ([]KustoResponse, error) {
-	var recs []KustoResponse
-
-	iter, err := client.Query(ctx, PMCDBName, cmd)
-	if err != nil {
-		return nil, err
-	}
-
-	err = iter.DoOnRowOrError(
-		func(row *table.Row, err *errors.Error) error {
-			if err != nil {
-				return err
-			}
-			rec := KustoResponse{}
-			if err := row.ToStruct(&rec); err != nil {
-				return err
-			}
-			recs = append(recs, rec)
-
-			return nil
-		})
-	return recs, nil
-}
@@ -0,0 +1,132 @@
+package datasource
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-kusto-go/kusto"
+	"github.com/Azure/azure-kusto-go/kusto/data/types"
+)
+
+// PMCClassInteractive is the class of the downloads matching none of the rules, e.g. the installs by people.
+const PMCClassInteractive = "interactive"
+
+// PMCClassRule classifies the downloads of the clients, e.g. as CI or container builds.
+// A download is in the class when its user agent matches UserAgent, or its client IP is in any of ClientRanges.
+type PMCClassRule struct {
+	Class string
+	// UserAgent is a regular expression in the RE2 syntax, it matches nothing when it's empty.
+	UserAgent string
+	// ClientRanges are IPv4 or IPv6 ranges in the CIDR notation, e.g. the ranges of the hosted CI runners.
+	ClientRanges []string
+}
+
+// kustoClassExpr returns the KQL expression of the class of a download with the columns ClientIp and UserAgent,
+// along with the definitions and the values of the parameters it uses. The first matching rule wins.
+func kustoClassExpr(rules []PMCClassRule) (string, map[string]kusto.ParamType, map[string]interface{}) {
+	defMap := map[string]kusto.ParamType{"defaultClass": {Type: types.String}}
+	paramMap := map[string]interface{}{"defaultClass": PMCClassInteractive}
+	if len(rules) == 0 {
+		return "defaultClass", defMap, paramMap
+	}
+
+	var cases []string
+	for i, rule := range rules {
+		var conds []string
+		if len(rule.UserAgent) != 0 {
+			name := fmt.Sprintf("classUserAgent%d", i)
+			defMap[name] = kusto.ParamType{Type: types.String}
+			paramMap[name] = rule.UserAgent
+			conds = append(conds, fmt.Sprintf("UserAgent matches regex %s", name))
+		}
+		// the functions of the IPv4 ranges don't match the IPv6 addresses, the families are matched separately.
+		ipv4Ranges, ipv6Ranges := splitIPRanges(rule.ClientRanges)
+		if len(ipv4Ranges) != 0 {
+			name := fmt.Sprintf("classClientRanges%d", i)
+			defMap[name] = kusto.ParamType{Type: types.Dynamic}
+			paramMap[name] = ipv4Ranges
+			conds = append(conds, fmt.Sprintf("ipv4_is_in_any_range(ClientIp, %s)", name))
+		}
+		if len(ipv6Ranges) != 0 {
+			name := fmt.Sprintf("classClientRangesV6%d", i)
+			defMap[name] = kusto.ParamType{Type: types.Dynamic}
+			paramMap[name] = ipv6Ranges
+			conds = append(conds, fmt.Sprintf("(ClientIp contains \":\" and ipv6_is_in_any_range(ClientIp, %s))", name))
+		}
+		if len(conds) == 0 {
+			continue
+		}
+
+		name := fmt.Sprintf("class%d", i)
+		defMap[name] = kusto.ParamType{Type: types.String}
+		paramMap[name] = rule.Class
+		cases = append(cases, fmt.Sprintf("%s, %s", strings.Join(conds, " or "), name))
+	}
+	if len(cases) == 0 {
+		return "defaultClass", defMap, paramMap
+	}
+	return fmt.Sprintf("case(%s, defaultClass)", strings.Join(cases, ", ")), defMap, paramMap
+}
+
+// splitIPRanges splits the ranges in the CIDR notation into the IPv4 and the IPv6 ones, the IPv6 ones contain colons.
+func splitIPRanges(ranges []string) (ipv4 []string, ipv6 []string) {
+	for _, r := range ranges {
+		if strings.Contains(r, ":") {
+			ipv6 = append(ipv6, r)
+		} else {
+			ipv4 = append(ipv4, r)
+		}
+	}
+	return ipv4, ipv6
+}
+
+// pmcClassifier classifies the access log rows in the same way as the expression of kustoClassExpr.
+type pmcClassifier []pmcClassMatcher
+
+type pmcClassMatcher struct {
+	class     string
+	userAgent *regexp.Regexp
+	ranges    []*net.IPNet
+}
+
+func newPMCClassifier(rules []PMCClassRule) (pmcClassifier, error) {
+	var classifier pmcClassifier
+	for _, rule := range rules {
+		m := pmcClassMatcher{class: rule.Class}
+		if len(rule.UserAgent) != 0 {
+			reg, err := regexp.Compile(rule.UserAgent)
+			if err != nil {
+				return nil, fmt.Errorf("user agent of class %s: %+v", rule.Class, err)
+			}
+			m.userAgent = reg
+		}
+		for _, r := range rule.ClientRanges {
+			_, ipNet, err := net.ParseCIDR(r)
+			if err != nil {
+				return nil, fmt.Errorf("client range of class %s: %+v", rule.Class, err)
+			}
+			m.ranges = append(m.ranges, ipNet)
+		}
+		classifier = append(classifier, m)
+	}
+	return classifier, nil
+}
+
+func (c pmcClassifier) classify(row AccessLogRow) string {
+	// as the Kusto functions, the IPv4 ranges only match the IPv4 addresses, and the IPv6 ranges the IPv6 ones.
+	ip := net.ParseIP(row.ClientIp)
+	ipv6 := strings.Contains(row.ClientIp, ":")
+	for _, m := range c {
+		if m.userAgent != nil && m.userAgent.MatchString(row.UserAgent) {
+			return m.class
+		}
+		for _, r := range m.ranges {
+			if ip != nil && (r.IP.To4() == nil) == ipv6 && r.Contains(ip) {
+				return m.class
+			}
+		}
+	}
+	return PMCClassInteractive
+}
@@ -0,0 +1,35 @@
+package datasource
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPMCClassifierIPv6(t *testing.T) {
+	rules := []PMCClassRule{{Class: "ci", ClientRanges: []string{"10.0.0.0/8", "2a01:111:f403::/48"}}}
+	classifier, err := newPMCClassifier(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{
+		"10.1.2.3":         "ci",
+		"2a01:111:f403::1": "ci",
+		"2a01:111:f404::1": PMCClassInteractive,
+		"::ffff:10.1.2.3":  PMCClassInteractive,
+		"192.168.0.1":      PMCClassInteractive,
+		"":                 PMCClassInteractive,
+	} {
+		if got := classifier.classify(AccessLogRow{ClientIp: ip}); got != want {
+			t.Errorf("class of %q is %q, want %q", ip, got, want)
+		}
+	}
+
+	// the IPv6 ranges are matched by the IPv6 function of Kusto.
+	expr, _, paramMap := kustoClassExpr(rules)
+	if !strings.Contains(expr, "ipv4_is_in_any_range(ClientIp, classClientRanges0)") || !strings.Contains(expr, "ipv6_is_in_any_range(ClientIp, classClientRangesV60)") {
+		t.Errorf("expression %s doesn't match both families", expr)
+	}
+	if v4, v6 := paramMap["classClientRanges0"].([]string), paramMap["classClientRangesV60"].([]string); len(v4) != 1 || len(v6) != 1 || v4[0] != "10.0.0.0/8" || v6[0] != "2a01:111:f403::/48" {
+		t.Errorf("ranges %v and %v, want the IPv4 and IPv6 ones apart", v4, v6)
+	}
+}
@@ -0,0 +1,180 @@
+package datasource
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aztfy-download-counter/job/pmcutils"
+)
+
+// AccessLogRow is a row of the HttpAccessLog table of PMC.
+type AccessLogRow struct {
+	PreciseTimeStamp time.Time `json:"PreciseTimeStamp"`
+	Path             string    `json:"path"`
+	Method           string    `json:"method"`
+	Code             string    `json:"code"`
+	ClientIp         string    `json:"clientIp"`
+	UserAgent        string    `json:"userAgent"`
+}
+
+// FilePMCSource is a PMCSource serving the rows of HttpAccessLog from a file, one JSON object per line.
+// It filters the rows and parses their packages as the Kusto queries do, so that PMC can be counted without a cluster.
+type FilePMCSource struct {
+	rows       []AccessLogRow
+	packages   []string
+	classifier pmcClassifier
+}
+
+var _ PMCSource = &FilePMCSource{}
+
+// NewFilePMCSource reads the access log rows of the packages from the file at path, the downloads are classified by rules.
+func NewFilePMCSource(path string, packages []string, rules []PMCClassRule) (*FilePMCSource, error) {
+	classifier, err := newPMCClassifier(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []AccessLogRow
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var row AccessLogRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return nil, fmt.Errorf("%s:%d: %+v", path, line, err)
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &FilePMCSource{rows: rows, packages: packages, classifier: classifier}, nil
+}
+
+func (s *FilePMCSource) QueryDailyCounts(_ context.Context, from, to time.Time) ([]PMCCount, error) {
+	// the counts without Count and Clients are the keys of the breakdowns.
+	counts := make(map[PMCCount]int64)
+	clients := make(map[PMCCount]map[string]bool)
+	var keys []PMCCount
+	add := func(k PMCCount, client string) {
+		if _, ok := counts[k]; !ok {
+			keys = append(keys, k)
+			clients[k] = make(map[string]bool)
+		}
+		counts[k]++
+		if len(client) != 0 {
+			clients[k][client] = true
+		}
+	}
+
+	for _, row := range s.downloads(from.AddDate(0, 0, -1), to) {
+		day := countDay(row.PreciseTimeStamp)
+		pkg, err := pmcutils.ParsePath(row.Path)
+		if err != nil {
+			add(PMCCount{Day: day, Breakdown: PMCBreakdownPath, Path: row.Path}, "")
+			continue
+		}
+
+		// the clients are counted exactly, while Kusto estimates them.
+		var client string
+		if len(row.ClientIp) != 0 || len(row.UserAgent) != 0 {
+			client = row.ClientIp + "|" + row.UserAgent
+		}
+		total := PMCCount{Day: day, Breakdown: PMCBreakdownTotal, Format: pkg.Format, Version: pkg.Version, Arch: pkg.Arch}
+		add(total, client)
+		class := total
+		class.Breakdown, class.Class = PMCBreakdownClass, s.classifier.classify(row)
+		add(class, client)
+		distro := total
+		distro.Breakdown, distro.Distro, distro.Release = PMCBreakdownDistro, pkg.Distro, pkg.Release
+		add(distro, client)
+	}
+
+	recs := make([]PMCCount, 0, len(keys))
+	for _, k := range keys {
+		rec := k
+		rec.Count = counts[k]
+		rec.Clients = int64(len(clients[k]))
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+func (s *FilePMCSource) Close() error {
+	return nil
+}
+
+// downloads returns the successful downloads of the rpm and deb packages after start till end.
+func (s *FilePMCSource) downloads(start, end time.Time) []AccessLogRow {
+	var rows []AccessLogRow
+	for _, row := range s.rows {
+		if !hasAnyTermFold(row.Path, s.packages) || !(containsFold(row.Path, "rpm") || containsFold(row.Path, ".deb")) || row.Method != "GET" || row.Code != "200" {
+			continue
+		}
+		if !row.PreciseTimeStamp.After(start) || row.PreciseTimeStamp.After(end) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// countDay returns the date whose count includes a download at t, a download at midnight is counted in the date.
+func countDay(t time.Time) time.Time {
+	t = t.UTC().Add(-time.Nanosecond)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+}
+
+// hasAnyTermFold reports whether any of terms is a whole term of s case-insensitively, as the has_any operator of Kusto.
+func hasAnyTermFold(s string, terms []string) bool {
+	for _, term := range terms {
+		if hasTermFold(s, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTermFold reports whether term is within s case-insensitively, and not adjacent to any alphanumeric character,
+// as the has operator of Kusto, which matches the maximal sequences of the alphanumeric characters.
+// e.g. aztfexport-0.14.0-1.x86_64.rpm has aztfexport, while aztfexport2-0.1.0-1.x86_64.rpm doesn't.
+func hasTermFold(s, term string) bool {
+	if len(term) == 0 {
+		return false
+	}
+	s, term = strings.ToLower(s), strings.ToLower(term)
+	for i := 0; i+len(term) <= len(s); {
+		j := strings.Index(s[i:], term)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(term)
+		if (start == 0 || !isAlphanumeric(s[start-1])) && (end == len(s) || !isAlphanumeric(s[end])) {
+			return true
+		}
+		i = start + 1
+	}
+	return false
+}
+
+func isAlphanumeric(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// containsFold reports whether substr is within s case-insensitively, as the contains operator of Kusto.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
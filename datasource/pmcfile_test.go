@@ -0,0 +1,92 @@
+package datasource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHasTermFold(t *testing.T) {
+	for _, c := range []struct {
+		s, term string
+		want    bool
+	}{
+		{"/yumrepos/azurecore/aztfexport-0.14.0-1-x86_64.rpm", "aztfexport", true},
+		{"/ubuntu/22.04/prod/pool/main/a/aztfexport/aztfexport_0.14.0_amd64.deb", "AZTFEXPORT", true},
+		{"/rhel/9/prod/Packages/a/aztfexport2-0.1.0-1.x86_64.rpm", "aztfexport", false},
+		{"/ubuntu/22.04/prod/pool/main/m/myaztfexport/myaztfexport_1.0.0_amd64.deb", "aztfexport", false},
+		{"/rhel/9/prod/Packages/a/aztfy-0.10.0-1.x86_64.rpm", "aztf", false},
+		{"/rhel/9/prod/Packages/a/azure-cli-2.58.0-1.el9.x86_64.rpm", "azure-cli", true},
+		{"aztfexport", "", false},
+	} {
+		if got := hasTermFold(c.s, c.term); got != c.want {
+			t.Errorf("hasTermFold(%q, %q) = %v, want %v", c.s, c.term, got, c.want)
+		}
+	}
+}
+
+func TestFilePMCSourceQueryDailyCounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.jsonl")
+	rows := `{"PreciseTimeStamp": "2024-03-01T08:00:00Z", "path": "/rhel/9/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm", "method": "GET", "code": "200", "clientIp": "10.0.0.1"}
+{"PreciseTimeStamp": "2024-03-01T09:00:00Z", "path": "/rhel/9/prod/Packages/a/aztfexport2-0.1.0-1.x86_64.rpm", "method": "GET", "code": "200", "clientIp": "10.0.0.2"}
+{"PreciseTimeStamp": "2024-03-01T10:00:00Z", "path": "/rhel/9/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm", "method": "GET", "code": "304", "clientIp": "10.0.0.3"}
+`
+	if err := os.WriteFile(path, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewFilePMCSource(path, []string{"aztfexport"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := source.QueryDailyCounts(context.Background(), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := PMCCount{Day: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), Breakdown: PMCBreakdownTotal, Format: "rpm", Version: "0.14.0", Arch: "x86_64", Count: 1, Clients: 1}
+	class, distro := total, total
+	class.Breakdown, class.Class = PMCBreakdownClass, PMCClassInteractive
+	distro.Breakdown, distro.Distro, distro.Release = PMCBreakdownDistro, "rhel", "9"
+	if want := []PMCCount{total, class, distro}; !reflect.DeepEqual(counts, want) {
+		t.Fatalf("counts %+v, want the download of aztfexport-0.14.0-1.x86_64.rpm %+v", counts, want)
+	}
+}
+
+func TestFilePMCSourceDistinctClients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.jsonl")
+	// a client downloading from two repositories and by two classes is counted once in total.
+	rows := `{"PreciseTimeStamp": "2024-03-01T08:00:00Z", "path": "/rhel/9/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm", "method": "GET", "code": "200", "clientIp": "10.0.0.1", "userAgent": "libdnf"}
+{"PreciseTimeStamp": "2024-03-01T09:00:00Z", "path": "/rhel/8/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm", "method": "GET", "code": "200", "clientIp": "10.0.0.1", "userAgent": "libdnf"}
+{"PreciseTimeStamp": "2024-03-01T10:00:00Z", "path": "/rhel/8/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm", "method": "GET", "code": "200", "clientIp": "10.0.0.2", "userAgent": "libdnf"}
+{"PreciseTimeStamp": "2024-03-01T11:00:00Z", "path": "/rhel/8/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm", "method": "GET", "code": "200", "clientIp": "10.0.0.1", "userAgent": "libdnf GitHubActions"}
+`
+	if err := os.WriteFile(path, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewFilePMCSource(path, []string{"aztfexport"}, []PMCClassRule{{Class: "ci", UserAgent: "GitHubActions"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, err := source.QueryDailyCounts(context.Background(), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string][2]int64)
+	for _, c := range counts {
+		got[c.Breakdown+"/"+c.Class+c.Distro+c.Release] = [2]int64{c.Count, c.Clients}
+	}
+	want := map[string][2]int64{
+		"total/":            {4, 3},
+		"class/interactive": {3, 2},
+		"class/ci":          {1, 1},
+		"distro/rhel9":      {1, 1},
+		"distro/rhel8":      {3, 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("[count, clients] %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package datasource
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	kustoerrors "github.com/Azure/azure-kusto-go/kusto/data/errors"
+	"github.com/google/go-github/v50/github"
+)
+
+// StatusError is returned when an HTTP API responds with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	// RetryAfter is how long the server asks to wait before retrying, zero when it doesn't tell.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// retryable tells whether an error of the sources is transient, and how long the server asks to wait before retrying.
+// It's the retry.Classifier of the sources.
+func retryable(err error) (time.Duration, bool) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter, retryableStatus(statusErr.StatusCode)
+	}
+
+	// the rate limits are waited by the GitHub client, they are not retried once more.
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &rateErr) || errors.As(err, &abuseErr) {
+		return 0, false
+	}
+	var githubErr *github.ErrorResponse
+	if errors.As(err, &githubErr) && githubErr.Response != nil {
+		return parseRetryAfter(githubErr.Response.Header.Get("Retry-After")), retryableStatus(githubErr.Response.StatusCode)
+	}
+
+	var kustoHttpErr *kustoerrors.HttpError
+	if errors.As(err, &kustoHttpErr) {
+		return 0, retryableStatus(kustoHttpErr.StatusCode)
+	}
+	var kustoErr *kustoerrors.Error
+	if errors.As(err, &kustoErr) {
+		return 0, kustoerrors.Retry(kustoErr)
+	}
+
+	// the network failures, including the timeouts.
+	var netErr net.Error
+	return 0, errors.As(err, &netErr)
+}
+
+func retryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses the Retry-After header in either seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if len(v) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
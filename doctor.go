@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"aztfy-download-counter/config"
+	"aztfy-download-counter/database"
+	"aztfy-download-counter/datasource"
+)
+
+type doctorCheck struct {
+	name  string
+	check func(ctx context.Context) (string, error)
+}
+
+func newDoctorCommand() command {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	common := registerCommonFlags(fs, false)
+	pmcKustoEndpoint := fs.String("kusto-endpoint", "", "the end point of PMC kusto, skipped when empty")
+
+	return command{
+		flags: fs,
+		short: "Check the credentials and endpoints used by the other commands.",
+		run: func(ctx context.Context) error {
+			cfg, err := common.loadConfig(func(cfg *config.Config) error {
+				if len(*pmcKustoEndpoint) != 0 {
+					cfg.PMC.KustoEndpoint = *pmcKustoEndpoint
+				}
+				return nil
+			}, config.Config.ValidateDatabase)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := withTimeout(ctx, cfg.Timeouts.Run)
+			defer cancel()
+
+			checks := []doctorCheck{
+				{name: "database", check: func(ctx context.Context) (string, error) {
+					return checkStores(ctx, common, cfg)
+				}},
+				{name: "github", check: func(ctx context.Context) (string, error) {
+					var errs error
+					remaining := 0
+					client, err := newGithubClient(cfg)
+					if err != nil {
+						return "", err
+					}
+					for _, repo := range cfg.Github.Repos {
+						owner, name, _ := config.SplitRepo(repo)
+						var err error
+						if remaining, err = client.Check(ctx, owner, name); err != nil {
+							errs = errors.Join(errs, fmt.Errorf("%s: %+v", repo, err))
+						}
+					}
+					return fmt.Sprintf("%d requests left in the rate limit", remaining), errs
+				}},
+				{name: "homebrew", check: func(ctx context.Context) (string, error) {
+					client, err := datasource.NewHomebrewClient(cfg.Homebrew.ApiUrl, cfg.Homebrew.Formula, nil, cfg.Homebrew.Retry.Policy(), newLogger("[HomebrewClient]\t"))
+					if err != nil {
+						return "", err
+					}
+					_, err = client.FetchDownloadCount(ctx)
+					return client.ApiUrl(), err
+				}},
+				{name: "kusto", check: func(ctx context.Context) (string, error) {
+					if len(cfg.PMC.AccessLogFile) == 0 && len(cfg.PMC.KustoEndpoint) == 0 {
+						return "skipped, no endpoint", nil
+					}
+					// the class rules are resolved as a run does, the published client ranges are fetched.
+					rules, err := pmcClassRules(ctx, cfg)
+					if err != nil {
+						return "", err
+					}
+					if len(cfg.PMC.AccessLogFile) != 0 {
+						_, err := datasource.NewFilePMCSource(cfg.PMC.AccessLogFile, cfg.PMC.Packages, rules)
+						return "access log file " + cfg.PMC.AccessLogFile, err
+					}
+					return checkKusto(ctx, cfg.PMC.KustoEndpoint, cfg.PMC.Database)
+				}},
+			}
+
+			failed := 0
+			for _, c := range checks {
+				detail, err := runCheck(ctx, cfg, c)
+				if err != nil {
+					failed++
+					fmt.Printf("[FAIL] %s: %+v\n", c.name, err)
+					continue
+				}
+				fmt.Printf("[OK]   %s: %s\n", c.name, detail)
+			}
+
+			if failed != 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			}
+			return nil
+		},
+	}
+}
+
+// runCheck runs c within the job deadline, so that an unreachable endpoint doesn't block the other checks.
+func runCheck(ctx context.Context, cfg config.Config, c doctorCheck) (string, error) {
+	ctx, cancel := withTimeout(ctx, cfg.Timeouts.Job)
+	defer cancel()
+	return c.check(ctx)
+}
+
+// checkStores reads an item which doesn't exist from every container, it's expected to be not found.
+func checkStores(ctx context.Context, common *commonFlags, cfg config.Config) (string, error) {
+	newStore, err := common.storeFactory(ctx, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	partitions, err := sourcePartitions(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	var errs error
+	for _, source := range sources {
+		if len(partitions[source]) == 0 {
+			continue
+		}
+		store, err := newStore(source)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %+v", containerName(cfg, source), err))
+			continue
+		}
+		_, err = store.Read(ctx, partitions[source][0], "doctor")
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			errs = errors.Join(errs, fmt.Errorf("%s: %+v", containerName(cfg, source), err))
+		}
+	}
+	return "all containers are readable", errs
+}
+
+func checkKusto(ctx context.Context, endpoint, dbName string) (string, error) {
+	client, err := datasource.AuthKusto(endpoint)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	return endpoint, datasource.CheckKusto(ctx, client, dbName)
+}
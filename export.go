@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"aztfy-download-counter/config"
+	"aztfy-download-counter/job"
+)
+
+func newExportCommand() command {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	common := registerCommonFlags(fs, false)
+	source := fs.String("source", "", "the source to export: github, homebrew or pmc")
+	from := fs.String("from", "", "the first day to export, e.g. 2023-04-11")
+	to := fs.String("to", "", "the last day to export, defaults to today")
+	output := fs.String("o", "", "the file to write to, defaults to stdout")
+
+	return command{
+		flags: fs,
+		short: "Dump the saved items of a source for a range of days as JSON lines.",
+		run: func(ctx context.Context) error {
+			if !slices.Contains(sources, *source) {
+				return fmt.Errorf("unknown source %q", *source)
+			}
+			start, end, err := parseDateRange(*from, *to)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := common.loadConfig(nil, config.Config.ValidateDatabase)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := withTimeout(ctx, cfg.Timeouts.Run)
+			defer cancel()
+
+			partitions, err := sourcePartitions(cfg)
+			if err != nil {
+				return err
+			}
+			newStore, err := common.storeFactory(ctx, cfg)
+			if err != nil {
+				return fmt.Errorf("init db client error: %+v", err)
+			}
+			store, err := newStore(*source)
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = os.Stdout
+			if len(*output) != 0 {
+				f, err := os.Create(*output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+
+			for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+				date := d.Format(job.TimeFormat)
+				for _, pk := range partitions[*source] {
+					items, err := store.Query(ctx, pk, date)
+					if err != nil {
+						return fmt.Errorf("query %s %s: %+v", pk, date, err)
+					}
+					for _, item := range items {
+						if _, err := fmt.Fprintf(w, "%s\n", item); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -2,31 +2,59 @@ package job
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	"aztfy-download-counter/database"
 	"aztfy-download-counter/datasource"
 	"aztfy-download-counter/job/githubutils"
-	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 	"github.com/google/go-github/v50/github"
 )
 
+// LegacyGithubRepo is the only repository counted before multiple repositories are supported,
+// the ids of its items don't contain the repository.
+const LegacyGithubRepo = "Azure/aztfexport"
+
+// legacyGithubAssetFormats are the formats counted before the format was in the ids of the items, their ids don't have it.
+var legacyGithubAssetFormats = map[string]bool{"zip": true, "msi": true, "tar.gz": true}
+
 type GithubWorker struct {
-	ContainerInitFunc func() (*azcosmos.ContainerClient, error)
-	Logger            *log.Logger
-	Date              string
+	StoreInitFunc func() (database.Store, error)
+	// CacheStoreInitFunc returns the store caching the release lists. Optional.
+	CacheStoreInitFunc func() (database.Store, error)
+	Logger             *log.Logger
+	Date               string
+	Client             *datasource.GithubClient
+	Owner              string
+	Repo               string
+	// Assets parses the names of the release assets.
+	Assets githubutils.AssetParsers
 }
 
 func (w GithubWorker) Run(ctx context.Context) {
-	container, err := w.ContainerInitFunc()
+	store, err := w.StoreInitFunc()
 	if err != nil {
 		w.Logger.Println(err)
 		return
 	}
 
+	var cache datasource.GithubCache
+	if w.CacheStoreInitFunc != nil {
+		cacheStore, err := w.CacheStoreInitFunc()
+		if err != nil {
+			w.Logger.Println(fmt.Errorf("init cache failed, fetch without it: %v", err))
+		} else {
+			cache = githubCacheDBClient{Store: cacheStore, Date: w.Date}
+		}
+	}
+
 	w.Logger.Println("fetch data")
-	ghResp, err := datasource.FetchGitHubDownloadCount(ctx)
+	ghResp, err := w.Client.FetchDownloadCount(ctx, w.Owner, w.Repo, cache)
+	rate := w.Client.Rate()
+	w.Logger.Printf("rate limit: %d/%d remaining, resets at %v", rate.Remaining, rate.Limit, rate.Reset.Time)
 	if err != nil {
 		w.Logger.Println(err)
 		return
@@ -36,10 +64,12 @@ func (w GithubWorker) Run(ctx context.Context) {
 	w.Logger.Println("write Github data to db")
 	osTypeMap := make(map[string][]database.GithubVersion)
 	for _, item := range items {
-		prevObj, err := w.getPrevObj(ctx, container, item)
-		if err == nil {
-			item.TodayCount = w.calcTodayCnt(prevObj, item)
+		prevObj, err := w.getPrevObj(ctx, store, item)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			w.Logger.Println(fmt.Errorf("read previous data of %s failed: %v", item.Id, err))
+			return
 		}
+		item.TodayCount = w.calcTodayCnt(prevObj, item)
 
 		array, ok := osTypeMap[item.OsType]
 		if !ok {
@@ -51,11 +81,11 @@ func (w GithubWorker) Run(ctx context.Context) {
 	}
 
 	for osType, array := range osTypeMap {
-		err = database.BatchUpsert(ctx, container, osType, array)
+		summary, err := database.BatchUpsert(ctx, store, osType, array)
 		if err != nil {
-			w.Logger.Println(err)
-			return
+			w.Logger.Println(fmt.Errorf("write partition %s failed: %+v", osType, err))
 		}
+		w.Logger.Printf("write partition %s: %s", osType, summary)
 	}
 
 	w.Logger.Println("done")
@@ -69,10 +99,10 @@ func (w GithubWorker) calcTodayCnt(prevObj database.GithubVersion, currObj datab
 	return currObj.TotalCount - prevObj.TotalCount
 }
 
-func (w GithubWorker) getPrevObj(ctx context.Context, container *azcosmos.ContainerClient, item database.GithubVersion) (database.GithubVersion, error) {
+func (w GithubWorker) getPrevObj(ctx context.Context, store database.Store, item database.GithubVersion) (database.GithubVersion, error) {
 	prevDate := idx2DateStr(dateStr2Idx(item.CountDate) - 1)
 	prevObj := database.GithubVersion{}
-	err := database.ReadItem(ctx, container, item.OsType, w.newGithubItemId(prevDate, item.OsType, item.Arch, item.Ver), &prevObj)
+	err := database.ReadItem(ctx, store, item.OsType, w.newGithubItemId(prevDate, item.Format, item.OsType, item.Arch, item.Ver), &prevObj)
 	if err != nil {
 		return prevObj, err
 	}
@@ -80,33 +110,103 @@ func (w GithubWorker) getPrevObj(ctx context.Context, container *azcosmos.Contai
 	return prevObj, nil
 }
 
-func (w GithubWorker) newGithubItemId(date, osType, arch, ver string) string {
-	return fmt.Sprintf("%s-%s-%s-%s", date, osType, arch, ver)
+func (w GithubWorker) repo() string {
+	return w.Owner + "/" + w.Repo
+}
+
+func (w GithubWorker) newGithubItemId(date, format, osType, arch, ver string) string {
+	id := fmt.Sprintf("%s-%s-%s-%s", date, osType, arch, ver)
+	if w.repo() != LegacyGithubRepo {
+		// "/" is not allowed in the id of cosmos db, while ":" is not allowed in the names of GitHub,
+		// so that the repository is not ambiguous, e.g. a-b/c and a/b-c.
+		id = fmt.Sprintf("%s-%s:%s-%s-%s-%s", date, w.Owner, w.Repo, osType, arch, ver)
+	}
+	if legacyGithubAssetFormats[format] {
+		return id
+	}
+	return id + "-" + format
 }
 
 func (w GithubWorker) processReleases(releases []*github.RepositoryRelease, countDate string) []database.GithubVersion {
 	var output []database.GithubVersion
+	var unknown []string
 	for _, r := range releases {
 		for _, a := range r.Assets {
 			if a.Name == nil || a.DownloadCount == nil {
 				continue
 			}
 
-			version, osType, arch, err := githubutils.ParseTagName(*a.Name, *a.ContentType)
+			asset, err := w.Assets.Parse(*a.Name)
 			if err != nil {
+				if !errors.Is(err, githubutils.ErrIgnoredAsset) {
+					unknown = append(unknown, *a.Name)
+				}
 				continue
 			}
 
 			output = append(output, database.GithubVersion{
-				Id:          w.newGithubItemId(countDate, string(osType), arch, version),
+				Id:          w.newGithubItemId(countDate, asset.Format, string(asset.OsType), asset.Arch, asset.Version),
+				Repo:        w.repo(),
 				CountDate:   countDate,
-				Ver:         version,
-				OsType:      string(osType),
-				Arch:        arch,
+				Ver:         asset.Version,
+				OsType:      string(asset.OsType),
+				Arch:        asset.Arch,
+				Format:      asset.Format,
 				TotalCount:  *a.DownloadCount,
-				PublishDate: r.PublishedAt.Time,
+				PublishDate: r.GetPublishedAt().Time,
 			})
 		}
 	}
+	if len(unknown) != 0 {
+		w.Logger.Printf("%d assets match no rule, skipped: %s", len(unknown), strings.Join(unknown, ", "))
+	}
 	return output
 }
+
+// githubCacheDBClient saves the release lists in the database for conditional requests.
+type githubCacheDBClient struct {
+	Store database.Store
+	Date  string
+}
+
+func (c githubCacheDBClient) Get(ctx context.Context, owner, repo string, page int) (datasource.GithubCachedPage, bool, error) {
+	var item database.GithubReleasePage
+	err := database.ReadItem(ctx, c.Store, owner+"/"+repo, c.newItemId(owner, repo, page), &item)
+	if errors.Is(err, database.ErrNotFound) {
+		return datasource.GithubCachedPage{}, false, nil
+	}
+	if err != nil {
+		return datasource.GithubCachedPage{}, false, err
+	}
+
+	data := datasource.GithubCachedPage{
+		ETag:         item.ETag,
+		LastModified: item.LastModified,
+	}
+	if err := json.Unmarshal(item.Releases, &data.Releases); err != nil {
+		return datasource.GithubCachedPage{}, false, err
+	}
+	return data, true, nil
+}
+
+func (c githubCacheDBClient) Put(ctx context.Context, owner, repo string, page int, data datasource.GithubCachedPage) error {
+	releases, err := json.Marshal(data.Releases)
+	if err != nil {
+		return err
+	}
+
+	return database.CreateOrUpdateItem(ctx, c.Store, owner+"/"+repo, database.GithubReleasePage{
+		Id:           c.newItemId(owner, repo, page),
+		Repo:         owner + "/" + repo,
+		Page:         page,
+		ETag:         data.ETag,
+		LastModified: data.LastModified,
+		Releases:     releases,
+		FetchDate:    c.Date,
+	})
+}
+
+// newItemId separates owner, repo and page by ":", which is not allowed in the names of GitHub.
+func (c githubCacheDBClient) newItemId(owner, repo string, page int) string {
+	return fmt.Sprintf("%s:%s:%d", owner, repo, page)
+}
@@ -0,0 +1,158 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"aztfy-download-counter/database"
+	"aztfy-download-counter/datasource"
+	"aztfy-download-counter/job/githubutils"
+	"aztfy-download-counter/retry"
+)
+
+func TestNewGithubItemId(t *testing.T) {
+	legacy := GithubWorker{Owner: "Azure", Repo: "aztfexport"}
+	if id := legacy.newGithubItemId("2024-01-02", "zip", "linux", "amd64", "v0.14.0"); id != "2024-01-02-linux-amd64-v0.14.0" {
+		t.Errorf("id of %s is %s, want the legacy one", LegacyGithubRepo, id)
+	}
+	if id := legacy.newGithubItemId("2024-01-02", "deb", "linux", "amd64", "v0.14.0"); id != "2024-01-02-linux-amd64-v0.14.0-deb" {
+		t.Errorf("id of a deb asset is %s", id)
+	}
+
+	other := GithubWorker{Owner: "Azure", Repo: "aztfy"}
+	if id := other.newGithubItemId("2024-01-02", "zip", "linux", "amd64", "v0.14.0"); id != "2024-01-02-Azure:aztfy-linux-amd64-v0.14.0" {
+		t.Errorf("id of Azure/aztfy is %s", id)
+	}
+
+	a := GithubWorker{Owner: "a-b", Repo: "c"}.newGithubItemId("2024-01-02", "zip", "linux", "amd64", "v1.0.0")
+	b := GithubWorker{Owner: "a", Repo: "b-c"}.newGithubItemId("2024-01-02", "zip", "linux", "amd64", "v1.0.0")
+	if a == b {
+		t.Errorf("a-b/c and a/b-c have the same id %s", a)
+	}
+}
+
+func TestGithubCacheItemId(t *testing.T) {
+	var c githubCacheDBClient
+	if id := c.newItemId("Azure", "aztfexport", 1); id != "Azure:aztfexport:1" {
+		t.Errorf("id of the first page of Azure/aztfexport is %s", id)
+	}
+	if a, b := c.newItemId("a-b", "c", 1), c.newItemId("a", "b-c", 1); a == b {
+		t.Errorf("a-b/c and a/b-c have the same id %s", a)
+	}
+}
+
+// newGithubStandIn replays the recorded release list of Azure/aztfexport, with the download counts increased by delta.
+// The list is versioned by its ETag, a conditional request of the current version is not modified.
+func newGithubStandIn(t *testing.T, delta *int, notModified *int) *httptest.Server {
+	t.Helper()
+	recorded, err := os.ReadFile("testdata/github_releases.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/Azure/aztfexport/releases" || r.URL.Query().Get("page") != "1" {
+			http.NotFound(w, r)
+			return
+		}
+		etag := fmt.Sprintf(`"%d"`, *delta)
+		if r.Header.Get("If-None-Match") == etag {
+			*notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var releases []map[string]interface{}
+		if err := json.Unmarshal(recorded, &releases); err != nil {
+			t.Error(err)
+		}
+		for _, release := range releases {
+			for _, asset := range release["assets"].([]interface{}) {
+				asset := asset.(map[string]interface{})
+				asset["download_count"] = asset["download_count"].(float64) + float64(*delta)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("ETag", etag)
+		json.NewEncoder(w).Encode(releases)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGithubWorkerRun(t *testing.T) {
+	ctx := context.Background()
+	delta, notModified := 0, 0
+	srv := newGithubStandIn(t, &delta, &notModified)
+
+	logger := log.New(io.Discard, "", 0)
+	client, err := datasource.NewGithubClient(srv.URL, srv.Client(), "", retry.Policy{MaxAttempts: 1}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets, err := githubutils.NewAssetParsers(githubutils.DefaultAssetRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := database.NewMemoryStore(database.GithubPartitionKey)
+	cacheStore := database.NewMemoryStore(database.GithubCachePartitionKey)
+	run := func(date string) {
+		GithubWorker{
+			StoreInitFunc:      func() (database.Store, error) { return store, nil },
+			CacheStoreInitFunc: func() (database.Store, error) { return cacheStore, nil },
+			Logger:             logger,
+			Date:               date,
+			Client:             client,
+			Owner:              "Azure",
+			Repo:               "aztfexport",
+			Assets:             assets,
+		}.Run(ctx)
+	}
+	read := func(osType, id string) database.GithubVersion {
+		t.Helper()
+		var item database.GithubVersion
+		if err := database.ReadItem(ctx, store, osType, id, &item); err != nil {
+			t.Fatalf("read %s: %v", id, err)
+		}
+		return item
+	}
+
+	run("2024-03-21")
+	for osType, want := range map[string]int{"linux": 3, "darwin": 1, "windows": 2} {
+		items, err := database.QueryItem(ctx, store, osType, "2024-03-21", database.GithubVersion{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != want {
+			t.Errorf("%d items of %s, want %d: %+v", len(items), osType, want, items)
+		}
+	}
+	if item := read("linux", "2024-03-21-linux-amd64-v0.14.1"); item.TotalCount != 120 || item.TodayCount != -1 || item.Format != "zip" {
+		t.Errorf("zip of the first day: %+v", item)
+	}
+	if item := read("linux", "2024-03-21-linux-amd64-0.14.1-deb"); item.TotalCount != 12 || item.Format != "deb" {
+		t.Errorf("deb of the first day: %+v", item)
+	}
+
+	// the release list is not modified, it's read from the cache.
+	run("2024-03-22")
+	if notModified != 1 {
+		t.Errorf("%d conditional requests are not modified, want 1", notModified)
+	}
+	if item := read("windows", "2024-03-22-windows-x64-v0.14.0"); item.TotalCount != 2150 || item.TodayCount != 0 {
+		t.Errorf("msi of the day not modified: %+v", item)
+	}
+
+	delta = 5
+	run("2024-03-23")
+	if item := read("windows", "2024-03-23-windows-x64-v0.14.0"); item.TotalCount != 2155 || item.TodayCount != 5 {
+		t.Errorf("msi of the day with 5 downloads: %+v", item)
+	}
+}
@@ -1,49 +1,185 @@
 package githubutils
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 
 	"aztfy-download-counter/database"
 )
 
-func ParseTagName(tagName string, contentType string) (version string, osType database.OsType, arch string, err error) {
-	switch contentType {
-	case "application/zip":
-		return ParseTagNameForZip(tagName)
-	case "application/x-msdownload":
-		return ParseTagNameForMsi(tagName)
-	case "application/gzip":
-		return ParseTagNameForGz(tagName)
-	default:
-		return "", "", "", fmt.Errorf("parse failed")
+// AssetRule is a parser of the names of the release assets, the content types reported by GitHub are not reliable.
+type AssetRule struct {
+	// Format is the format of the matching assets, e.g. zip.
+	Format string
+	// Pattern is a regular expression of the asset names with the named groups version, os and arch.
+	// The os group is optional when OsType is set, and the arch group is optional.
+	Pattern string
+	// OsType is the OS of the assets whose names don't tell it, e.g. windows of msi.
+	OsType string
+	// OsTypes are the OSes the os group may match, the assets of the other OSes are rejected.
+	// They are required with the os group, as the items are partitioned by the OSes the rules yield.
+	OsTypes []string
+	// Ignore skips the matching assets, e.g. the checksums.
+	Ignore bool
+}
+
+// DefaultAssetRules returns the rules of the assets of aztfexport.
+func DefaultAssetRules() []AssetRule {
+	osTypes := []string{string(database.OsTypeWindows), string(database.OsTypeLinux), string(database.OsTypeDarwin)}
+	return []AssetRule{
+		{Format: "checksum", Pattern: `(?i)(^|[._-])(checksums?|sha256sums?)(\.txt)?$|\.(sha256|sha512|sig|asc|pem|sbom|spdx|spdx\.json|intoto\.jsonl)$`, Ignore: true},
+		{Format: "zip", Pattern: `^.*_(?P<version>v\d+\.\d+\.\d+)_(?P<os>[a-z]+)_(?P<arch>.+)\.zip$`, OsTypes: osTypes},
+		{Format: "msi", Pattern: `^.*_(?P<version>v\d+\.\d+\.\d+)_(?P<arch>.+)\.msi$`, OsType: string(database.OsTypeWindows)},
+		{Format: "tar.gz", Pattern: `^.*_(?P<version>v?\d+\.\d+\.\d+)_(?P<os>[^_]+)_(?P<arch>.+)\.tar\.gz$`, OsTypes: osTypes},
+		{Format: "tar.xz", Pattern: `^.*_(?P<version>v?\d+\.\d+\.\d+)_(?P<os>[^_]+)_(?P<arch>.+)\.tar\.xz$`, OsTypes: osTypes},
+		{Format: "deb", Pattern: `^.*_(?P<version>v?\d+\.\d+\.\d+)(?:-[^_]+)?_(?P<arch>[^_]+)\.deb$`, OsType: string(database.OsTypeLinux)},
+		{Format: "rpm", Pattern: `^.*-(?P<version>v?\d+\.\d+\.\d+)-[^-]+?[.-](?P<arch>[A-Za-z0-9_]+)\.rpm$`, OsType: string(database.OsTypeLinux)},
+		{Format: "pkg", Pattern: `^.*_(?P<version>v?\d+\.\d+\.\d+)(?:_(?:darwin|macos))?_(?P<arch>[^_]+)\.pkg$`, OsType: string(database.OsTypeDarwin)},
+	}
+}
+
+// Asset is a release asset parsed from its name.
+type Asset struct {
+	Version string
+	OsType  database.OsType
+	Arch    string
+	Format  string
+}
+
+// ErrIgnoredAsset is returned for the assets matching a rule to ignore them.
+var ErrIgnoredAsset = errors.New("asset is ignored")
+
+// AssetParser parses the asset names matching a rule.
+type AssetParser struct {
+	rule    AssetRule
+	pattern *regexp.Regexp
+	groups  map[string]int
+}
+
+// NewAssetParser compiles a rule, the pattern of the assets to count must have the version group, and the os group or OsType.
+func NewAssetParser(rule AssetRule) (AssetParser, error) {
+	if rule.Format == "" {
+		return AssetParser{}, errors.New("format: must not be empty")
+	}
+	pattern, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return AssetParser{}, fmt.Errorf("pattern: %+v", err)
+	}
+
+	groups := make(map[string]int)
+	for i, name := range pattern.SubexpNames() {
+		if name != "" {
+			groups[name] = i
+		}
+	}
+	if !rule.Ignore {
+		if _, ok := groups["version"]; !ok {
+			return AssetParser{}, fmt.Errorf("pattern: %q has no version group", rule.Pattern)
+		}
+		if _, ok := groups["os"]; !ok && rule.OsType == "" {
+			return AssetParser{}, fmt.Errorf("pattern: %q has no os group, while os is not set", rule.Pattern)
+		}
+		if _, ok := groups["os"]; ok && len(rule.OsTypes) == 0 {
+			return AssetParser{}, fmt.Errorf("os_types: must not be empty, as the pattern %q has the os group", rule.Pattern)
+		}
+	}
+
+	return AssetParser{rule: rule, pattern: pattern, groups: groups}, nil
+}
+
+// parse returns false when the name doesn't match.
+func (p AssetParser) parse(name string) (Asset, bool) {
+	result := p.pattern.FindStringSubmatch(name)
+	if result == nil {
+		return Asset{}, false
+	}
+	group := func(name string) string {
+		if i, ok := p.groups[name]; ok {
+			return result[i]
+		}
+		return ""
 	}
+
+	asset := Asset{
+		Version: group("version"),
+		OsType:  database.OsType(strings.ToLower(group("os"))),
+		Arch:    group("arch"),
+		Format:  p.rule.Format,
+	}
+	if asset.OsType == "" {
+		asset.OsType = database.OsType(p.rule.OsType)
+	}
+	return asset, true
 }
 
-func ParseTagNameForZip(tagName string) (version string, osType database.OsType, arch string, err error) {
-	reg := regexp.MustCompile(`(?m).*_(v\d*\.\d*\.\d*)_([a-z]+)_(.+)\.zip`)
-	result := reg.FindStringSubmatch(tagName)
-	if len(result) != 4 {
-		return "", "", "", fmt.Errorf("parse failed")
+// osTypes returns the OSes of the assets the rule yields.
+func (p AssetParser) osTypes() []string {
+	if p.rule.Ignore {
+		return nil
+	}
+	if _, ok := p.groups["os"]; !ok {
+		return []string{p.rule.OsType}
 	}
-	return result[1], database.OsType(strings.ToLower(result[2])), result[3], nil
+	osTypes := append([]string(nil), p.rule.OsTypes...)
+	if p.rule.OsType != "" {
+		osTypes = append(osTypes, p.rule.OsType)
+	}
+	return osTypes
 }
 
-func ParseTagNameForMsi(tagName string) (version string, osType database.OsType, arch string, err error) {
-	reg := regexp.MustCompile(`.*_(v\d*\.\d*\.\d*)_(.+)\.msi`)
-	result := reg.FindStringSubmatch(tagName)
-	if len(result) != 3 {
-		return "", "", "", fmt.Errorf("parse failed")
+// AssetParsers is a registry of the asset parsers, they are tried in order and the first matching one wins.
+type AssetParsers []AssetParser
+
+// NewAssetParsers compiles the rules in order.
+func NewAssetParsers(rules []AssetRule) (AssetParsers, error) {
+	parsers := make(AssetParsers, 0, len(rules))
+	var errs error
+	for i, rule := range rules {
+		parser, err := NewAssetParser(rule)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("asset rule %d: %+v", i, err))
+			continue
+		}
+		parsers = append(parsers, parser)
 	}
-	return result[1], database.OsTypeWindows, result[2], nil
+	return parsers, errs
 }
 
-func ParseTagNameForGz(tagName string) (version string, osType database.OsType, arch string, err error) {
-	reg := regexp.MustCompile(`(?mU).*_(v{0,1}\d*\.\d*\.\d*)_(.+)_(.+)\.tar\.gz`)
-	result := reg.FindStringSubmatch(tagName)
-	if len(result) != 4 {
-		return "", "", "", fmt.Errorf("parse failed")
+// Parse returns the asset of a name, ErrIgnoredAsset is returned when it matches a rule to ignore it.
+func (p AssetParsers) Parse(name string) (Asset, error) {
+	for _, parser := range p {
+		asset, ok := parser.parse(name)
+		if !ok {
+			continue
+		}
+		if parser.rule.Ignore {
+			return Asset{}, ErrIgnoredAsset
+		}
+		if osTypes := parser.osTypes(); !slices.Contains(osTypes, string(asset.OsType)) {
+			return Asset{}, fmt.Errorf("the asset %q is of the os %q, not any of %v", name, asset.OsType, osTypes)
+		}
+		return asset, nil
+	}
+	return Asset{}, fmt.Errorf("no rule matches the asset %q", name)
+}
+
+// OsTypes returns the OSes of the assets the parsers yield in order, they are the partitions of the items.
+func (p AssetParsers) OsTypes() []string {
+	set := make(map[string]bool)
+	for _, parser := range p {
+		for _, osType := range parser.osTypes() {
+			set[osType] = true
+		}
+	}
+
+	osTypes := make([]string, 0, len(set))
+	for osType := range set {
+		osTypes = append(osTypes, osType)
 	}
-	return result[1], database.OsType(strings.ToLower(result[2])), result[3], nil
+	sort.Strings(osTypes)
+	return osTypes
 }
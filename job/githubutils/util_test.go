@@ -0,0 +1,70 @@
+package githubutils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"aztfy-download-counter/database"
+)
+
+func TestAssetParsersParse(t *testing.T) {
+	parsers, err := NewAssetParsers(DefaultAssetRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]Asset{
+		"aztfexport_v0.14.1_linux_amd64.zip":    {Version: "v0.14.1", OsType: database.OsTypeLinux, Arch: "amd64", Format: "zip"},
+		"aztfexport_v0.14.1_x64.msi":            {Version: "v0.14.1", OsType: database.OsTypeWindows, Arch: "x64", Format: "msi"},
+		"aztfexport-0.14.1-1.x86_64.rpm":        {Version: "0.14.1", OsType: database.OsTypeLinux, Arch: "x86_64", Format: "rpm"},
+		"aztfexport_0.14.1_darwin_arm64.tar.gz": {Version: "0.14.1", OsType: database.OsTypeDarwin, Arch: "arm64", Format: "tar.gz"},
+	} {
+		got, err := parsers.Parse(name)
+		if err != nil || got != want {
+			t.Errorf("Parse(%q) = %+v, %v, want %+v", name, got, err, want)
+		}
+	}
+
+	if _, err := parsers.Parse("aztfexport_v0.14.1_SHA256SUMS"); !errors.Is(err, ErrIgnoredAsset) {
+		t.Errorf("checksums are not ignored: %v", err)
+	}
+	// the OSes not of the rules are not counted, they would be written to the partitions never read.
+	if got, err := parsers.Parse("aztfexport_v0.14.1_freebsd_amd64.zip"); err == nil || errors.Is(err, ErrIgnoredAsset) {
+		t.Errorf("asset of freebsd is parsed as %+v, %v", got, err)
+	}
+}
+
+func TestAssetParsersOsTypes(t *testing.T) {
+	parsers, err := NewAssetParsers(DefaultAssetRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := parsers.OsTypes(), []string{"darwin", "linux", "windows"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OsTypes() = %v, want %v", got, want)
+	}
+
+	parsers, err = NewAssetParsers([]AssetRule{
+		{Format: "zip", Pattern: `^.*_(?P<version>v\d+\.\d+\.\d+)_(?P<os>[a-z]+)_(?P<arch>.+)\.zip$`, OsTypes: []string{"linux", "freebsd"}},
+		{Format: "dmg", Pattern: `^.*_(?P<version>v\d+\.\d+\.\d+)\.dmg$`, OsType: "darwin"},
+		{Format: "checksum", Pattern: `\.sha256$`, Ignore: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := parsers.OsTypes(), []string{"darwin", "freebsd", "linux"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OsTypes() = %v, want %v", got, want)
+	}
+	if got, err := parsers.Parse("aztfexport_v0.14.1_freebsd_amd64.zip"); err != nil || got.OsType != "freebsd" {
+		t.Errorf("asset of freebsd is parsed as %+v, %v", got, err)
+	}
+}
+
+func TestNewAssetParser(t *testing.T) {
+	if _, err := NewAssetParser(AssetRule{Format: "dmg", Pattern: `^.*\.dmg$`, OsType: "darwin"}); err == nil {
+		t.Error("rule without the version group is accepted")
+	}
+	if _, err := NewAssetParser(AssetRule{Format: "zip", Pattern: `^.*_(?P<version>v\d+\.\d+\.\d+)_(?P<os>[a-z]+)\.zip$`}); err == nil {
+		t.Error("rule with the os group but without os_types is accepted")
+	}
+}
@@ -2,13 +2,13 @@ package job
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"aztfy-download-counter/database"
 	"aztfy-download-counter/datasource"
-	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 	"github.com/ziyeqf/homebrewcalculator"
 )
 
@@ -22,19 +22,18 @@ const IndexStartDate = "2023-04-11"
 const TimeFormat = "2006-01-02"
 
 type homebrewDBClient struct {
-	ContainerInitFunc func() (*azcosmos.ContainerClient, error)
-	Logger            *log.Logger
-	Container         *azcosmos.ContainerClient
-	OsType            database.OsType
-	cache             map[int]homebrewcalculator.CountInfo
+	Logger *log.Logger
+	Store  database.Store
+	OsType database.OsType
+	cache  map[int]homebrewcalculator.CountInfo
 }
 
-func newHomebrewDBClient(container *azcosmos.ContainerClient, osType database.OsType, logger *log.Logger) homebrewDBClient {
+func newHomebrewDBClient(store database.Store, osType database.OsType, logger *log.Logger) homebrewDBClient {
 	return homebrewDBClient{
-		Logger:    logger,
-		Container: container,
-		OsType:    osType,
-		cache:     make(map[int]homebrewcalculator.CountInfo),
+		Logger: logger,
+		Store:  store,
+		OsType: osType,
+		cache:  make(map[int]homebrewcalculator.CountInfo),
 	}
 }
 
@@ -60,12 +59,16 @@ func (h homebrewDBClient) Get(ctx context.Context, idx int) (homebrewcalculator.
 		TotalCounts: make(map[homebrewcalculator.Span]int),
 	}
 
-	resp, err := database.QueryItem(ctx, h.Container, string(h.OsType), date, database.HomebrewVersion{})
+	resp, err := database.QueryItem(ctx, h.Store, string(h.OsType), date, database.HomebrewVersion{})
+	if err != nil {
+		// don't take a failed read as a missing day, the calculator would overwrite it.
+		return result, err
+	}
 	if len(resp) == 0 {
 		return result, nil
 	}
 
-	if err == nil && len(resp) == 1 {
+	if len(resp) == 1 {
 		result.Count = resp[0].TodayCount
 	}
 
@@ -82,7 +85,7 @@ func (h homebrewDBClient) Get(ctx context.Context, idx int) (homebrewcalculator.
 }
 
 func (h homebrewDBClient) Set(ctx context.Context, idx int, data homebrewcalculator.CountInfo) error {
-	dbObjects, err := database.QueryItem(ctx, h.Container, string(h.OsType), idx2DateStr(idx), database.HomebrewVersion{})
+	dbObjects, err := database.QueryItem(ctx, h.Store, string(h.OsType), idx2DateStr(idx), database.HomebrewVersion{})
 	if err != nil {
 		return err
 	}
@@ -115,7 +118,7 @@ func (h homebrewDBClient) Set(ctx context.Context, idx int, data homebrewcalcula
 	}
 
 	h.Logger.Printf("update homebrew data to db: %+v\r", dbObj)
-	err = database.CreateOrUpdateItem(ctx, h.Container, string(h.OsType), dbObj)
+	err = database.CreateOrUpdateItem(ctx, h.Store, string(h.OsType), dbObj)
 	if err != nil {
 		return err
 	}
@@ -126,36 +129,41 @@ func (h homebrewDBClient) Set(ctx context.Context, idx int, data homebrewcalcula
 }
 
 type HomebrewWorker struct {
-	Logger            *log.Logger
-	ContainerInitFunc func() (container *azcosmos.ContainerClient, err error)
-	OsTypes           []database.OsType
-	Date              string
+	Logger        *log.Logger
+	StoreInitFunc func() (database.Store, error)
+	OsTypes       []database.OsType
+	Date          string
+	Client        *datasource.HomebrewClient
+	Formula       string
+	Spans         []homebrewcalculator.Span
 }
 
 func (w HomebrewWorker) Run(ctx context.Context) {
-	container, err := w.ContainerInitFunc()
+	store, err := w.StoreInitFunc()
 	if err != nil {
 		w.Logger.Println(err)
 		return
 	}
 
 	w.Logger.Println("fetch data")
-	apiFailure := false
-	hbResp, err := datasource.FetchHomeBrewDownloadCount()
+	hbResp, err := w.Client.FetchDownloadCount(ctx)
 	if err != nil {
-		w.Logger.Println("fetch homebrew data failed: %+v\r", err)
-		apiFailure = true
+		w.Logger.Printf("fetch homebrew data failed, record it as an api failure: %+v\r", err)
 	}
 
-	var brewVersions []database.HomebrewVersion
+	w.Logger.Println("write raw data to db")
 	for _, osType := range []database.OsType{database.OsTypeDarwin, database.OsTypeLinux} {
-		brewVersions = append(brewVersions, w.generateHomeBrewVersion(*hbResp, osType, w.Date, apiFailure))
-	}
+		var item database.HomebrewVersion
+		if hbResp != nil {
+			item = w.generateHomeBrewVersion(*hbResp, osType, w.Date)
+		} else {
+			var ok bool
+			if item, ok = w.apiFailureVersion(ctx, store, osType); !ok {
+				continue
+			}
+		}
 
-	w.Logger.Println("write raw data to db")
-	for _, item := range brewVersions {
-		err := database.CreateOrUpdateItem(ctx, container, item.OsType, item)
-		if err != nil {
+		if err := database.CreateOrUpdateItem(ctx, store, item.OsType, item); err != nil {
 			w.Logger.Println(err)
 			return
 		}
@@ -163,9 +171,9 @@ func (w HomebrewWorker) Run(ctx context.Context) {
 
 	w.Logger.Println("begin calc")
 	for _, osType := range w.OsTypes {
-		var calcDBClient homebrewcalculator.DatabaseClient = newHomebrewDBClient(container, osType, w.Logger)
+		var calcDBClient homebrewcalculator.DatabaseClient = newHomebrewDBClient(store, osType, w.Logger)
 		calcLogger := log.New(w.Logger.Writer(), w.Logger.Prefix()+"[Calc] ", 0)
-		calculator := homebrewcalculator.NewCalculator([]homebrewcalculator.Span{ThirtyDaysSpan, NinetyDaysSpan, OneYearSpan}, &calcDBClient, calcLogger)
+		calculator := homebrewcalculator.NewCalculator(w.Spans, &calcDBClient, calcLogger)
 		err := calculator.Calc(ctx, dateStr2Idx(w.Date))
 		if err != nil {
 			w.Logger.Println(err)
@@ -176,7 +184,31 @@ func (w HomebrewWorker) Run(ctx context.Context) {
 	return
 }
 
-func (w HomebrewWorker) generateHomeBrewVersion(input datasource.BrewJson, osType database.OsType, date string, apiFailure bool) database.HomebrewVersion {
+// apiFailureVersion returns the item recording the api failure of the day, its counts are left to the calculator.
+// It returns false when the item shouldn't be written, e.g. the day has been fetched by a former run.
+func (w HomebrewWorker) apiFailureVersion(ctx context.Context, store database.Store, osType database.OsType) (database.HomebrewVersion, bool) {
+	item := database.HomebrewVersion{
+		Id:         newHomebrewItemId(w.Date, string(osType)),
+		OsType:     string(osType),
+		CountDate:  w.Date,
+		ApiFailure: true,
+	}
+
+	var existing database.HomebrewVersion
+	err := database.ReadItem(ctx, store, string(osType), item.Id, &existing)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return item, true
+	case err != nil:
+		w.Logger.Println(fmt.Errorf("read %s failed, skip recording the api failure: %v", item.Id, err))
+		return item, false
+	default:
+		w.Logger.Printf("keep %s written before", item.Id)
+		return item, false
+	}
+}
+
+func (w HomebrewWorker) generateHomeBrewVersion(input datasource.BrewJson, osType database.OsType, date string) database.HomebrewVersion {
 	var i datasource.Install
 	if osType == database.OsTypeDarwin {
 		i = input.Analytics.Install
@@ -188,10 +220,9 @@ func (w HomebrewWorker) generateHomeBrewVersion(input datasource.BrewJson, osTyp
 		Id:             newHomebrewItemId(date, string(osType)),
 		OsType:         string(osType),
 		CountDate:      date,
-		ThirtyDayCount: i.ThirtyDays.Aztfy,
-		NinetyDayCount: i.NinetyDays.Aztfy,
-		OneYearCount:   i.OneYear.Aztfy,
-		ApiFailure:     apiFailure,
+		ThirtyDayCount: i.ThirtyDays[w.Formula],
+		NinetyDayCount: i.NinetyDays[w.Formula],
+		OneYearCount:   i.OneYear[w.Formula],
 	}
 
 	return output
@@ -0,0 +1,115 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"aztfy-download-counter/database"
+	"aztfy-download-counter/datasource"
+	"aztfy-download-counter/retry"
+	"github.com/ziyeqf/homebrewcalculator"
+)
+
+// newTestHomebrewWorker returns a worker of the formula API stand-in handler.
+func newTestHomebrewWorker(t *testing.T, store database.Store, handler http.HandlerFunc) HomebrewWorker {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	logger := log.New(io.Discard, "", 0)
+	client, err := datasource.NewHomebrewClient(srv.URL+"/api/formula/", datasource.HomeBrewFormula, srv.Client(), retry.Policy{MaxAttempts: 1}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return HomebrewWorker{
+		Logger: logger,
+		StoreInitFunc: func() (database.Store, error) {
+			return store, nil
+		},
+		OsTypes: []database.OsType{database.OsTypeDarwin, database.OsTypeLinux},
+		Date:    "2024-01-02",
+		Client:  client,
+		Formula: datasource.HomeBrewFormula,
+		Spans:   []homebrewcalculator.Span{ThirtyDaysSpan, NinetyDaysSpan, OneYearSpan},
+	}
+}
+
+func failingHomebrewHandler(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "unavailable", http.StatusServiceUnavailable)
+}
+
+func TestHomebrewWorkerRun(t *testing.T) {
+	ctx := context.Background()
+	recorded, err := os.ReadFile("testdata/homebrew_aztfexport.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := database.NewMemoryStore(database.HomebrewPartitionKey)
+	newTestHomebrewWorker(t, store, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/formula/aztfexport.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(recorded)
+	}).Run(ctx)
+
+	want := map[string]database.HomebrewVersion{
+		"darwin": {Id: "2024-01-02-darwin", OsType: "darwin", ThirtyDayCount: 212, NinetyDayCount: 640, OneYearCount: 2391, CountDate: "2024-01-02"},
+		"linux":  {Id: "2024-01-02-linux", OsType: "linux", ThirtyDayCount: 18, NinetyDayCount: 51, OneYearCount: 197, CountDate: "2024-01-02"},
+	}
+	for osType, want := range want {
+		var item database.HomebrewVersion
+		if err := database.ReadItem(ctx, store, osType, want.Id, &item); err != nil {
+			t.Fatal(err)
+		}
+		item.TodayCount = 0 // left to the calculator
+		if item != want {
+			t.Errorf("item of %s is %+v, want %+v", osType, item, want)
+		}
+	}
+}
+
+func TestHomebrewWorkerApiFailure(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore(database.HomebrewPartitionKey)
+	newTestHomebrewWorker(t, store, failingHomebrewHandler).Run(ctx)
+
+	for _, osType := range []string{"darwin", "linux"} {
+		var item database.HomebrewVersion
+		if err := database.ReadItem(ctx, store, osType, "2024-01-02-"+osType, &item); err != nil {
+			t.Fatalf("read the item of %s: %v", osType, err)
+		}
+		if !item.ApiFailure || item.CountDate != "2024-01-02" {
+			t.Errorf("item of %s is %+v, want an api failure of 2024-01-02", osType, item)
+		}
+	}
+}
+
+func TestHomebrewWorkerApiFailureKeepsFetched(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore(database.HomebrewPartitionKey)
+	fetched := database.HomebrewVersion{Id: "2024-01-02-darwin", OsType: "darwin", ThirtyDayCount: 30, NinetyDayCount: 90, OneYearCount: 365, CountDate: "2024-01-02"}
+	if err := database.CreateOrUpdateItem(ctx, store, fetched.OsType, fetched); err != nil {
+		t.Fatal(err)
+	}
+
+	newTestHomebrewWorker(t, store, failingHomebrewHandler).Run(ctx)
+
+	var item database.HomebrewVersion
+	if err := database.ReadItem(ctx, store, "darwin", fetched.Id, &item); err != nil {
+		t.Fatal(err)
+	}
+	if item.ApiFailure || item.ThirtyDayCount != 30 || item.OneYearCount != 365 {
+		t.Errorf("item fetched by a former run is overwritten: %+v", item)
+	}
+	if err := database.ReadItem(ctx, store, "linux", "2024-01-02-linux", &item); errors.Is(err, database.ErrNotFound) || !item.ApiFailure {
+		t.Errorf("item of linux is %+v, %v, want an api failure", item, err)
+	}
+}
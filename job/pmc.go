@@ -2,191 +2,383 @@ package job
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"regexp"
-	"strings"
+	"sort"
 	"time"
 
 	"aztfy-download-counter/database"
 	"aztfy-download-counter/datasource"
-	"github.com/Azure/azure-kusto-go/kusto"
-	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"aztfy-download-counter/job/pmcutils"
 )
 
 const startDate = "2022-10-21"
 
+// pmcLookbackDays is how many days before the start date are queried with the range.
+const pmcLookbackDays = 10
+
+// pmcArch is an arch of a package format, the arches are named by the format, e.g. x86_64 for rpm and amd64 for deb.
+// The arch alone is the partition key, the ids of the deb items have the format as some names overlap, e.g. s390x.
+type pmcArch struct {
+	Format string
+	Arch   string
+}
+
+// pmcDownloads is the downloads of a version-arch from a distro release in a day, and the clients of them.
+type pmcDownloads struct {
+	Count   int64
+	Clients int64
+}
+
+// pmcVersionKey is a version-arch.
+type pmcVersionKey struct {
+	Version string
+	Arch    pmcArch
+}
+
+// pmcClassKey is a version-arch downloaded by a class of clients.
+type pmcClassKey struct {
+	Version string
+	Arch    pmcArch
+	Class   string
+}
+
+// pmcDistroKey is a version-arch downloaded from the repositories of a distro release.
+type pmcDistroKey struct {
+	Version string
+	Arch    pmcArch
+	Distro  string
+	Release string
+}
+
+// PMCWorker counts the downloads of the dates from StartDate to Date with a single query, and writes them in bulk.
 type PMCWorker struct {
-	ContainerInitFunc func() (*azcosmos.ContainerClient, error)
-	Logger            *log.Logger
-	KustoEndpoint     string
-	Date              string
+	StoreInitFunc  func() (database.Store, error)
+	SourceInitFunc func(ctx context.Context) (datasource.PMCSource, error)
+	Logger         *log.Logger
+	// StartDate is the first date to count, only Date is counted when it's empty.
+	StartDate string
+	Date      string
 }
 
 func (w PMCWorker) Run(ctx context.Context) {
-	container, err := w.ContainerInitFunc()
+	store, err := w.StoreInitFunc()
 	if err != nil {
 		w.Logger.Println(err)
 		return
 	}
 
-	w.Logger.Println("work on " + w.Date)
-	kustoClient, err := datasource.AuthKusto(w.KustoEndpoint)
+	end, err := time.Parse(TimeFormat, w.Date)
 	if err != nil {
-		w.Logger.Println(fmt.Errorf("auth kusto failed, skipped: %v", err))
+		w.Logger.Println(err)
 		return
 	}
-	defer func(kustoClient *kusto.Client) {
-		err := kustoClient.Close()
-		if err != nil {
+	start := end
+	if len(w.StartDate) != 0 {
+		if start, err = time.Parse(TimeFormat, w.StartDate); err != nil {
 			w.Logger.Println(err)
 			return
 		}
-	}(kustoClient)
+	}
 
-	datetime, err := time.Parse(TimeFormat, w.Date)
+	w.Logger.Printf("work on %s to %s", start.Format(TimeFormat), w.Date)
+	source, err := w.SourceInitFunc(ctx)
 	if err != nil {
-		w.Logger.Println(err)
+		w.Logger.Println(fmt.Errorf("init pmc source failed, skipped: %v", err))
 		return
 	}
+	defer func(source datasource.PMCSource) {
+		err := source.Close()
+		if err != nil {
+			w.Logger.Println(err)
+			return
+		}
+	}(source)
 
-	resp, err := datasource.QueryForPMC(ctx, kustoClient, datetime)
+	// the days before the start are queried as well, to patch the version-archs not downloaded on the first dates,
+	// and to count the total of the version-archs not in the database.
+	counts, err := source.QueryDailyCounts(ctx, start.AddDate(0, 0, -pmcLookbackDays), end)
 	if err != nil {
 		w.Logger.Println(err)
 		return
 	}
 
-	// [version][arch]PMCVersion
-	result := make(map[string]map[string]*database.PMCVersion)
-	for _, item := range resp {
-		version, arch, err := w.parseTagNameForRPM(item.Path)
-		if err != nil {
-			w.Logger.Printf("parseTagNameForRPM path: %v, error: %+v\r", item.Path, err)
-		}
-		if _, ok := result[version]; !ok {
-			result[version] = make(map[string]*database.PMCVersion)
-		}
-		if _, ok := result[version][arch]; !ok {
-			result[version][arch] = &database.PMCVersion{
-				Id:         w.newPMCItemId(w.Date, arch, version),
-				Date:       w.Date,
-				Ver:        version,
-				Arch:       arch,
-				TodayCount: 0,
+	// [date][version][arch]count
+	dailyCounts := make(map[string]map[string]map[pmcArch]int64)
+	// [date][version-arch]clients
+	dailyClients := make(map[string]map[pmcVersionKey]int64)
+	// [date][version-arch-class]downloads
+	classCounts := make(map[string]map[pmcClassKey]*pmcDownloads)
+	// [date][version-arch-distro]downloads
+	distroCounts := make(map[string]map[pmcDistroKey]*pmcDownloads)
+	// the paths which are not packages are reported, instead of being counted.
+	unclassified := make(map[string]*unclassifiedPath)
+	// the breakdowns are counted separately by the source, as their clients can't be summed up.
+	for _, c := range counts {
+		day := c.Day.UTC().Format(TimeFormat)
+		arch := pmcArch{Format: c.Format, Arch: c.Arch}
+		switch c.Breakdown {
+		case datasource.PMCBreakdownTotal:
+			if _, ok := dailyCounts[day]; !ok {
+				dailyCounts[day] = make(map[string]map[pmcArch]int64)
+				dailyClients[day] = make(map[pmcVersionKey]int64)
+			}
+			if _, ok := dailyCounts[day][c.Version]; !ok {
+				dailyCounts[day][c.Version] = make(map[pmcArch]int64)
+			}
+			dailyCounts[day][c.Version][arch] += c.Count
+			dailyClients[day][pmcVersionKey{Version: c.Version, Arch: arch}] += c.Clients
+		case datasource.PMCBreakdownClass:
+			if _, ok := classCounts[day]; !ok {
+				classCounts[day] = make(map[pmcClassKey]*pmcDownloads)
+			}
+			k := pmcClassKey{Version: c.Version, Arch: arch, Class: c.Class}
+			if _, ok := classCounts[day][k]; !ok {
+				classCounts[day][k] = &pmcDownloads{}
 			}
+			classCounts[day][k].Count += c.Count
+			classCounts[day][k].Clients += c.Clients
+		case datasource.PMCBreakdownDistro:
+			if _, ok := distroCounts[day]; !ok {
+				distroCounts[day] = make(map[pmcDistroKey]*pmcDownloads)
+			}
+			k := pmcDistroKey{Version: c.Version, Arch: arch, Distro: c.Distro, Release: c.Release}
+			if _, ok := distroCounts[day][k]; !ok {
+				distroCounts[day][k] = &pmcDownloads{}
+			}
+			distroCounts[day][k].Count += c.Count
+			distroCounts[day][k].Clients += c.Clients
+		case datasource.PMCBreakdownPath:
+			if _, ok := unclassified[c.Path]; !ok {
+				unclassified[c.Path] = &unclassifiedPath{Path: c.Path, Err: unparsedPathError(c.Path)}
+			}
+			unclassified[c.Path].Count += c.Count
+		default:
+			w.Logger.Printf("unknown breakdown %q of the pmc counts, skipped", c.Breakdown)
 		}
-		result[version][arch].TodayCount++
 	}
+	w.reportUnclassified(unclassified)
 
-	// a certain version-arch might not be downloaded in a day, but then downloaded the next day.
-	// to keep the data continues and avoid big query on pmc table, we use the previous day's data as a patch.
-	// so the version-arch combination of today is always more or equal to the previous day.
-	// while consider it's a cosmos db which is not easy to read the data we insert yesterday,
-	// we just query the data from kusto till we can get some data (or in 10 days in case there is a new version released).
-	// todo: it's not a good solution, very bad idea, actually.
-	var prevResp []datasource.KustoResponse
-	prevDate := datetime
-	for i := 0; prevResp == nil && i < 10; i++ {
-		prevDate = prevDate.AddDate(0, 0, -1)
-		prevResp, err = datasource.QueryForPMC(ctx, kustoClient, prevDate)
+	// [arch]PMCVersion
+	dbObjMap := make(map[string][]database.PMCVersion)
+	// the total counts of the previous date, [version][arch]count
+	var prevTotals map[string]map[pmcArch]int64
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		result, err := w.countDate(ctx, store, d, dailyCounts, dailyClients[d.Format(TimeFormat)], classCounts[d.Format(TimeFormat)], distroCounts[d.Format(TimeFormat)], prevTotals)
 		if err != nil {
 			w.Logger.Println(err)
+			return
+		}
+
+		prevTotals = make(map[string]map[pmcArch]int64)
+		for version, m := range result {
+			prevTotals[version] = make(map[pmcArch]int64)
+			for arch, item := range m {
+				prevTotals[version][arch] = item.TotalCount
+				w.Logger.Println("pmc data: ", *item)
+				dbObjMap[arch.Arch] = append(dbObjMap[arch.Arch], *item)
+			}
 		}
 	}
 
-	for _, item := range prevResp {
-		version, arch, err := w.parseTagNameForRPM(item.Path)
+	w.Logger.Println("write PMC data to db")
+	for arch, array := range dbObjMap {
+		// the partitions are written independently, a failed one doesn't stop the others.
+		summary, err := database.BatchUpsert(ctx, store, arch, array)
 		if err != nil {
-			w.Logger.Printf("parseTagNameForRPM path: %v, error: %+v\r", item.Path, err)
-		}
-		if _, ok := result[version]; !ok {
-			result[version] = make(map[string]*database.PMCVersion)
-		}
-		if _, ok := result[version][arch]; !ok {
-			result[version][arch] = &database.PMCVersion{
-				Id:         w.newPMCItemId(w.Date, arch, version),
-				Date:       w.Date,
-				Ver:        version,
-				Arch:       arch,
-				TodayCount: 0,
-			}
+			w.Logger.Println(fmt.Errorf("write partition %s failed: %+v", arch, err))
 		}
+		w.Logger.Printf("write partition %s: %s", arch, summary)
 	}
 
-	// calculate totalCount
+	w.Logger.Println("done")
+}
+
+// unclassifiedPath is a path which is not parsed into a package, with its downloads in a run.
+type unclassifiedPath struct {
+	Path  string
+	Count int64
+	Err   error
+}
+
+// unparsedPathError tells why a path is not parsed into a package by the source.
+func unparsedPathError(p string) error {
+	if _, err := pmcutils.ParsePath(p); err != nil {
+		return err
+	}
+	return errors.New("not parsed by the source, while it's a package")
+}
+
+// reportUnclassified logs the paths not parsed into a package, the most downloaded first.
+func (w PMCWorker) reportUnclassified(unclassified map[string]*unclassifiedPath) {
+	if len(unclassified) == 0 {
+		return
+	}
+
+	paths := make([]*unclassifiedPath, 0, len(unclassified))
+	var total int64
+	for _, p := range unclassified {
+		paths = append(paths, p)
+		total += p.Count
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Count != paths[j].Count {
+			return paths[i].Count > paths[j].Count
+		}
+		return paths[i].Path < paths[j].Path
+	})
+
+	w.Logger.Printf("%d downloads of %d paths are not classified as packages, skipped:", total, len(paths))
+	for _, p := range paths {
+		w.Logger.Printf("\t%d\t%s: %v", p.Count, p.Path, p.Err)
+	}
+}
+
+// countDate returns the items of a date, [version][arch]PMCVersion, with the clients of dailyClients and the downloads
+// broken down by classCounts and distroCounts.
+// The total of a version-arch starts from prevTotals, which are the totals of the previous date in the same run,
+// or from the database when it's not counted in the run.
+func (w PMCWorker) countDate(ctx context.Context, store database.Store, date time.Time, dailyCounts map[string]map[string]map[pmcArch]int64, dailyClients map[pmcVersionKey]int64, classCounts map[pmcClassKey]*pmcDownloads, distroCounts map[pmcDistroKey]*pmcDownloads, prevTotals map[string]map[pmcArch]int64) (map[string]map[pmcArch]*database.PMCVersion, error) {
+	dateStr := date.Format(TimeFormat)
+	result := make(map[string]map[pmcArch]*database.PMCVersion)
+	for version, m := range dailyCounts[dateStr] {
+		for arch, cnt := range m {
+			item := w.pmcVersion(result, dateStr, version, arch)
+			item.TodayCount += int(cnt)
+			item.TodayClients += int(dailyClients[pmcVersionKey{Version: version, Arch: arch}])
+		}
+	}
+	for k, downloads := range classCounts {
+		item := w.pmcVersion(result, dateStr, k.Version, k.Arch)
+		item.Classes = append(item.Classes, database.PMCClass{
+			Class:        k.Class,
+			TodayCount:   int(downloads.Count),
+			TodayClients: int(downloads.Clients),
+		})
+	}
+	for k, downloads := range distroCounts {
+		item := w.pmcVersion(result, dateStr, k.Version, k.Arch)
+		item.Distros = append(item.Distros, database.PMCDistro{
+			Distro:       k.Distro,
+			Release:      k.Release,
+			TodayCount:   int(downloads.Count),
+			TodayClients: int(downloads.Clients),
+		})
+	}
 	for _, m := range result {
-		for arch, item := range m {
-			prevTotalCount, err := w.getPrevTotalCount(ctx, container, kustoClient, arch, item.Ver)
-			if err != nil {
-				w.Logger.Println(fmt.Errorf("getting prevTotalCount failed, skipped: %v", err))
-				continue
+		for _, item := range m {
+			sort.Slice(item.Classes, func(i, j int) bool {
+				return item.Classes[i].Class < item.Classes[j].Class
+			})
+			sortDistros(item.Distros)
+		}
+	}
+
+	// a certain version-arch might not be downloaded in a day, but then downloaded the next day.
+	// to keep the data continues, we use the version-archs of the last day with downloads as a patch.
+	// so the version-arch combination of today is always more or equal to the previous day.
+	for i := 1; i <= pmcLookbackDays; i++ {
+		prev, ok := dailyCounts[date.AddDate(0, 0, -i).Format(TimeFormat)]
+		if !ok {
+			continue
+		}
+		for version, m := range prev {
+			for arch := range m {
+				w.pmcVersion(result, dateStr, version, arch)
 			}
-			item.TotalCount = prevTotalCount + int64(item.TodayCount)
 		}
+		break
 	}
 
-	// [arch]PMCVersion
-	dbObjMap := make(map[string][]database.PMCVersion)
+	// calculate totalCount
 	for _, m := range result {
 		for arch, item := range m {
-			w.Logger.Println("pmc data: ", *item)
-			dbObjMap[arch] = append(dbObjMap[arch], *item)
+			prevTotalCount, ok := prevTotals[item.Ver][arch]
+			if !ok {
+				var err error
+				prevTotalCount, err = w.getPrevTotalCount(ctx, store, date, arch, item.Ver, lookbackCount(dailyCounts, date, item.Ver, arch))
+				if err != nil {
+					var authErr *database.AuthError
+					if errors.As(err, &authErr) {
+						return nil, err
+					}
+					// don't write a TotalCount restarting from 0.
+					w.Logger.Println(fmt.Errorf("getting prevTotalCount failed, skipped: %v", err))
+					delete(m, arch)
+					continue
+				}
+			}
+			item.TotalCount = prevTotalCount + int64(item.TodayCount)
 		}
 	}
+	return result, nil
+}
 
-	w.Logger.Println("write PMC data to db")
-	for arch, array := range dbObjMap {
-		err = database.BatchUpsert(ctx, container, arch, array)
-		if err != nil {
-			w.Logger.Println(err)
-			return
+// sortDistros sorts the distros by their downloads, the most downloaded first.
+func sortDistros(distros []database.PMCDistro) {
+	sort.Slice(distros, func(i, j int) bool {
+		if distros[i].TodayCount != distros[j].TodayCount {
+			return distros[i].TodayCount > distros[j].TodayCount
 		}
-	}
-
-	w.Logger.Println("done")
+		if distros[i].Distro != distros[j].Distro {
+			return distros[i].Distro < distros[j].Distro
+		}
+		return distros[i].Release < distros[j].Release
+	})
 }
 
-func (w PMCWorker) getPrevTotalCount(ctx context.Context, container *azcosmos.ContainerClient, kustoClient *kusto.Client, arch string, version string) (int64, error) {
-	d, err := time.Parse(TimeFormat, w.Date)
-	if err != nil {
-		return 0, err
+// lookbackCount sums the downloads of a version-arch in the lookback days before date.
+func lookbackCount(dailyCounts map[string]map[string]map[pmcArch]int64, date time.Time, version string, arch pmcArch) int64 {
+	var cnt int64
+	for i := 1; i <= pmcLookbackDays; i++ {
+		cnt += dailyCounts[date.AddDate(0, 0, -i).Format(TimeFormat)][version][arch]
 	}
-	itemId := w.newPMCItemId(d.AddDate(0, 0, -1).Format(TimeFormat), arch, version)
+	return cnt
+}
+
+// getPrevTotalCount returns the total count of the day before date in the database.
+// If it's not there, the downloads in the lookback days are taken as the total.
+func (w PMCWorker) getPrevTotalCount(ctx context.Context, store database.Store, date time.Time, arch pmcArch, version string, lookbackCount int64) (int64, error) {
+	itemId := w.newPMCItemId(date.AddDate(0, 0, -1).Format(TimeFormat), arch, version)
 
 	prevObj := database.PMCVersion{}
-	err = database.ReadItem(ctx, container, arch, itemId, &prevObj)
+	err := database.ReadItem(ctx, store, arch.Arch, itemId, &prevObj)
 	if err != nil {
-		if !strings.Contains(err.Error(), "NotFound") {
+		if !errors.Is(err, database.ErrNotFound) {
 			return 0, err
 		}
-		// it costs a really long time and always get timed out to query such big data.
-		w.Logger.Printf("there is no data with id %s, query from pmc data base", itemId)
-		// as the data in cosmos has been guraranteed to be continues,
-		// we just limit the start date to 10 days to avoid big query.
-		s, _ := time.Parse(TimeFormat, d.AddDate(0, 0, -10).Format(TimeFormat))
-		cnt, err := datasource.QueryTotalCount(ctx, kustoClient, s, d, version, arch)
-		if err != nil {
-			w.Logger.Println(err)
-			return 0, err
-		}
-		return cnt, nil
+		// as the data in the database has been guaranteed to be continues, the lookback days are enough to start from.
+		w.Logger.Printf("there is no data with id %s, count the previous %d days", itemId, pmcLookbackDays)
+		return lookbackCount, nil
 	}
 
 	return prevObj.TotalCount, nil
 }
 
-func (w PMCWorker) parseTagNameForRPM(tagName string) (version string, arch string, err error) {
-	reg := regexp.MustCompile(`.*-(\d*\.\d*\.\d*)(-1-)?(-1.)?(.+)\.rpm`)
-	result := reg.FindStringSubmatch(tagName)
-	if len(result) != 4 && len(result) != 5 {
-		return "", "", fmt.Errorf("parse failed")
+// pmcVersion returns the item of a version-arch in result, it's added if missing.
+func (w PMCWorker) pmcVersion(result map[string]map[pmcArch]*database.PMCVersion, date, version string, arch pmcArch) *database.PMCVersion {
+	if _, ok := result[version]; !ok {
+		result[version] = make(map[pmcArch]*database.PMCVersion)
 	}
-	if len(result) == 4 {
-		return result[1], result[3], nil
+	if _, ok := result[version][arch]; !ok {
+		result[version][arch] = &database.PMCVersion{
+			Id:         w.newPMCItemId(date, arch, version),
+			Date:       date,
+			Ver:        version,
+			Arch:       arch.Arch,
+			Format:     arch.Format,
+			TodayCount: 0,
+		}
 	}
-	return result[1], result[4], nil
+	return result[version][arch]
 }
 
-func (w PMCWorker) newPMCItemId(date string, arch string, version string) string {
-	return fmt.Sprintf("%s-%s-%s", date, arch, version)
+// newPMCItemId returns the id of a version-arch, the ids of rpm are kept as they were before deb was counted.
+func (w PMCWorker) newPMCItemId(date string, arch pmcArch, version string) string {
+	id := fmt.Sprintf("%s-%s-%s", date, arch.Arch, version)
+	if arch.Format == pmcutils.FormatDeb {
+		return id + "-" + pmcutils.FormatDeb
+	}
+	return id
 }
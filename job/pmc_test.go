@@ -0,0 +1,139 @@
+package job
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"aztfy-download-counter/database"
+	"aztfy-download-counter/datasource"
+)
+
+func runTestPMCWorker(t *testing.T, store database.Store, startDate, date string) {
+	t.Helper()
+	rules := []datasource.PMCClassRule{{Class: "ci", UserAgent: `GitHubActions`}}
+	PMCWorker{
+		StoreInitFunc: func() (database.Store, error) { return store, nil },
+		SourceInitFunc: func(ctx context.Context) (datasource.PMCSource, error) {
+			return datasource.NewFilePMCSource("testdata/pmc_access.jsonl", []string{"aztfexport"}, rules)
+		},
+		Logger:    log.New(io.Discard, "", 0),
+		StartDate: startDate,
+		Date:      date,
+	}.Run(context.Background())
+}
+
+func readPMCVersion(t *testing.T, store database.Store, arch, id string) database.PMCVersion {
+	t.Helper()
+	var item database.PMCVersion
+	if err := database.ReadItem(context.Background(), store, arch, id, &item); err != nil {
+		t.Fatalf("read %s: %v", id, err)
+	}
+	return item
+}
+
+func TestPMCWorkerRun(t *testing.T) {
+	store := database.NewMemoryStore(database.PMCPartitionKey)
+	runTestPMCWorker(t, store, "2024-03-02", "2024-03-03")
+
+	// the downloads of a day are counted in the next date, a download at midnight is counted in the date.
+	want := database.PMCVersion{
+		Id:           "2024-03-02-x86_64-0.14.0",
+		Ver:          "0.14.0",
+		Arch:         "x86_64",
+		Format:       "rpm",
+		TodayCount:   4,
+		TotalCount:   4,
+		Date:         "2024-03-02",
+		TodayClients: 3,
+		Classes:      []database.PMCClass{{Class: datasource.PMCClassInteractive, TodayCount: 4, TodayClients: 3}},
+		Distros:      []database.PMCDistro{{Distro: "rhel", Release: "9", TodayCount: 4, TodayClients: 3}},
+	}
+	if got := readPMCVersion(t, store, "x86_64", want.Id); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if got := readPMCVersion(t, store, "amd64", "2024-03-02-amd64-0.14.0-deb"); got.Format != "deb" || got.TodayCount != 1 ||
+		!reflect.DeepEqual(got.Classes, []database.PMCClass{{Class: "ci", TodayCount: 1, TodayClients: 1}}) ||
+		!reflect.DeepEqual(got.Distros, []database.PMCDistro{{Distro: "ubuntu", Release: "22.04", TodayCount: 1, TodayClients: 1}}) {
+		t.Errorf("deb of 2024-03-02: %+v", got)
+	}
+
+	// the version-archs not downloaded are patched from the previous date, the new ones start from their downloads.
+	for _, c := range []struct {
+		arch, id   string
+		today      int
+		total      int64
+		hasClasses bool
+	}{
+		{"x86_64", "2024-03-03-x86_64-0.14.0", 1, 5, true},
+		{"x86_64", "2024-03-03-x86_64-0.14.1", 1, 1, true},
+		{"aarch64", "2024-03-03-aarch64-0.14.0", 0, 1, false},
+		{"amd64", "2024-03-03-amd64-0.14.0-deb", 0, 1, false},
+	} {
+		got := readPMCVersion(t, store, c.arch, c.id)
+		if got.TodayCount != c.today || got.TotalCount != c.total || (len(got.Classes) != 0) != c.hasClasses {
+			t.Errorf("%s: today %d, total %d, classes %v, want today %d and total %d", c.id, got.TodayCount, got.TotalCount, got.Classes, c.today, c.total)
+		}
+	}
+
+	// s390x is an arch of both rpm and deb.
+	if rpm, deb := readPMCVersion(t, store, "s390x", "2024-03-02-s390x-0.14.0"), readPMCVersion(t, store, "s390x", "2024-03-02-s390x-0.14.0-deb"); rpm.Format != "rpm" || deb.Format != "deb" || rpm.TodayCount != 1 || deb.TodayCount != 1 {
+		t.Errorf("s390x of rpm %+v and deb %+v", rpm, deb)
+	}
+
+	items, err := database.QueryItem(context.Background(), store, "x86_64", "2024-03-03", database.PMCVersion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Errorf("%d items of x86_64 on 2024-03-03, the paths not parsed as packages are counted: %+v", len(items), items)
+	}
+}
+
+func TestPMCWorkerRunContinuesTotal(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore(database.PMCPartitionKey)
+	prev := database.PMCVersion{Id: "2024-03-02-x86_64-0.14.0", Ver: "0.14.0", Arch: "x86_64", Format: "rpm", TodayCount: 4, TotalCount: 100, Date: "2024-03-02"}
+	if err := database.CreateOrUpdateItem(ctx, store, prev.Arch, prev); err != nil {
+		t.Fatal(err)
+	}
+
+	runTestPMCWorker(t, store, "", "2024-03-03")
+
+	if got := readPMCVersion(t, store, "x86_64", "2024-03-03-x86_64-0.14.0"); got.TotalCount != 101 {
+		t.Errorf("total continues from the database: %d, want 101", got.TotalCount)
+	}
+	// not in the database, the downloads in the lookback days are the total.
+	if got := readPMCVersion(t, store, "aarch64", "2024-03-03-aarch64-0.14.0"); got.TotalCount != 1 {
+		t.Errorf("total of the lookback days: %d, want 1", got.TotalCount)
+	}
+}
+
+func TestPMCWorkerRunDistinctClients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.jsonl")
+	// a client downloading from two distro releases is a client of the version-arch.
+	rows := `{"PreciseTimeStamp": "2024-03-01T08:00:00Z", "path": "/rhel/9/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm", "method": "GET", "code": "200", "clientIp": "10.0.0.1", "userAgent": "libdnf"}
+{"PreciseTimeStamp": "2024-03-01T09:00:00Z", "path": "/rhel/8/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm", "method": "GET", "code": "200", "clientIp": "10.0.0.1", "userAgent": "libdnf"}
+`
+	if err := os.WriteFile(path, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := database.NewMemoryStore(database.PMCPartitionKey)
+	PMCWorker{
+		StoreInitFunc: func() (database.Store, error) { return store, nil },
+		SourceInitFunc: func(ctx context.Context) (datasource.PMCSource, error) {
+			return datasource.NewFilePMCSource(path, []string{"aztfexport"}, nil)
+		},
+		Logger: log.New(io.Discard, "", 0),
+		Date:   "2024-03-02",
+	}.Run(context.Background())
+
+	got := readPMCVersion(t, store, "x86_64", "2024-03-02-x86_64-0.14.0")
+	if got.TodayCount != 2 || got.TodayClients != 1 || len(got.Distros) != 2 || got.Distros[0].TodayClients != 1 || got.Distros[1].TodayClients != 1 {
+		t.Errorf("got %+v, want 2 downloads of 1 client, 1 from each distro", got)
+	}
+}
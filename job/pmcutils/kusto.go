@@ -0,0 +1,65 @@
+package pmcutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KustoParsePath returns the KQL operators parsing the paths of the downloads in the column pathColumn as ParsePath does,
+// into the columns Format, Version, Arch, Distro and Release. They are all empty when the path is not a package.
+// The operators only embed the patterns and the names of this package, they are safe to add to a query.
+func KustoParsePath(pathColumn string) string {
+	return fmt.Sprintf(`
+| extend FilePath = tostring(split(%[1]s, "?")[0])
+| extend FileName = extract(@"[^/]*$", 0, FilePath), Segments = split(trim(@"/", FilePath), "/")
+| extend Format = case(FileName endswith_cs ".rpm", %[2]q, FileName endswith_cs ".deb", %[3]q, "")
+| extend Version = case(Format == %[2]q, extract(%[4]s, 2, FileName), Format == %[3]q, extract(%[5]s, 2, FileName), ""),
+    Arch = case(Format == %[2]q, extract(%[4]s, 4, FileName), Format == %[3]q, extract(%[5]s, 4, FileName), "")
+| extend Format = iff((Format == %[2]q and Arch in (%[6]s)) or (Format == %[3]q and Arch in (%[7]s)), Format, "")
+| extend Top = tostring(Segments[0]), RepoName = tostring(Segments[1]), IsRepo = tostring(Segments[0]) in ("repos", "yumrepos")
+| extend NamedDistro = extract(%[8]s, 1, RepoName), NamedRelease = extract(%[8]s, 2, RepoName),
+    PrefixedDistro = extract(%[9]s, 1, RepoName), PrefixedRelease = extract(%[9]s, 2, RepoName)
+| extend Distro = case(isempty(Format) or array_length(Segments) < 3, "",
+        IsRepo and isnotempty(NamedDistro), NamedDistro,
+        IsRepo and PrefixedDistro in (%[10]s), PrefixedDistro,
+        not(IsRepo) and Top in (%[10]s), Top, ""),
+    Release = case(isempty(Format) or array_length(Segments) < 3, "",
+        IsRepo and isnotempty(NamedDistro), NamedRelease,
+        IsRepo and PrefixedDistro in (%[10]s), PrefixedRelease,
+        not(IsRepo) and Top in (%[10]s), RepoName, "")
+| extend Distro = tolower(Distro), Release = tolower(Release)
+| extend Distro = coalesce(tostring(%[11]s[Distro]), Distro)
+| extend Release = coalesce(tostring(%[12]s[Distro][Release]), Release)
+| extend Version = iff(isempty(Format), "", Version), Arch = iff(isempty(Format), "", Arch)`,
+		pathColumn, FormatRPM, FormatDeb,
+		kustoVerbatim(rpmFilePattern), kustoVerbatim(debFilePattern),
+		kustoDynamic(sortedKeys(rpmArches)), kustoDynamic(sortedKeys(debArches)),
+		kustoVerbatim(repoNamePattern), kustoVerbatim(distroRepoNamePattern),
+		kustoDynamic(sortedKeys(distros)), kustoDynamic(distroAliases), kustoDynamic(codenames),
+	)
+}
+
+// kustoVerbatim returns s as a verbatim string literal of KQL, which doesn't escape the backslashes.
+func kustoVerbatim(s string) string {
+	return `@"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// kustoDynamic returns v as a dynamic literal of KQL.
+func kustoDynamic(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return "dynamic(" + string(b) + ")"
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
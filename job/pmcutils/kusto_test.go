@@ -0,0 +1,30 @@
+package pmcutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKustoParsePath(t *testing.T) {
+	kql := KustoParsePath("path")
+	// the query parses the paths by the same patterns and names as ParsePath.
+	for _, want := range []string{
+		`split(path, "?")`,
+		`@"` + rpmFilePattern + `"`,
+		`@"` + debFilePattern + `"`,
+		`@"` + repoNamePattern + `"`,
+		`@"` + distroRepoNamePattern + `"`,
+		`dynamic(["aarch64","armv7hl","i686","noarch","ppc64le","s390x","x86_64"])`,
+		`dynamic(["all","amd64","arm64","armhf","i386","ppc64el","s390x"])`,
+		`dynamic({"cbl-mariner":"azurelinux","mariner":"azurelinux"})`,
+		`"jammy":"22.04"`,
+	} {
+		if !strings.Contains(kql, want) {
+			t.Errorf("%s doesn't contain %s", kql, want)
+		}
+	}
+
+	if got := kustoVerbatim(`a"b\d`); got != `@"a""b\d"` {
+		t.Errorf("verbatim string %s", got)
+	}
+}
@@ -0,0 +1,168 @@
+package pmcutils
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The formats of the packages in PMC.
+const (
+	FormatRPM = "rpm"
+	FormatDeb = "deb"
+)
+
+// Package is a package file in PMC, parsed from the path of a download.
+type Package struct {
+	Format string
+	// Distro and Release are the repository of the package, e.g. ubuntu and 22.04, rhel and 8.
+	// They are empty when the path is not in a known repository layout.
+	Distro  string
+	Release string
+	Name    string
+	Version string
+	// PackageRelease is the release of an rpm or the revision of a deb, e.g. 1 of 0.14.0-1, it's optional for deb.
+	PackageRelease string
+	// Arch is named by the format, e.g. x86_64 for rpm and amd64 for deb.
+	Arch string
+}
+
+// The patterns are in the RE2 syntax, they are shared with the Kusto queries, see KustoParsePath.
+const (
+	// e.g. aztfexport-0.14.0-1.x86_64.rpm, aztfexport-0.14.0-1.el9.x86_64.rpm, aztfexport-0.14.0-1-x86_64.rpm
+	rpmFilePattern = `^([A-Za-z0-9][A-Za-z0-9._+-]*)-(\d+\.\d+\.\d+)-([^-/]+?)[.-]([A-Za-z0-9_]+)\.rpm$`
+	// e.g. aztfexport_0.14.0_amd64.deb, aztfexport_0.14.0-1_arm64.deb
+	debFilePattern = `^([A-Za-z0-9][A-Za-z0-9.+-]*)_(\d+\.\d+\.\d+)(?:-([^_/]+))?_([A-Za-z0-9]+)\.deb$`
+	// e.g. microsoft-ubuntu-jammy-prod, microsoft-rhel8.0-prod
+	repoNamePattern = `^microsoft-([a-z]+)-?(\d[\d.]*|[a-z]+)-(?:prod|insiders-fast|insiders-slow|testing)$`
+	// e.g. azurelinux-3.0-prod-ms-oss-x86_64, cbl-mariner-2.0-prod-Microsoft-x86_64
+	distroRepoNamePattern = `^([a-z]+(?:-[a-z]+)*)-(\d+(?:\.\d+)*)-`
+)
+
+var (
+	rpmFileReg        = regexp.MustCompile(rpmFilePattern)
+	debFileReg        = regexp.MustCompile(debFilePattern)
+	repoNameReg       = regexp.MustCompile(repoNamePattern)
+	distroRepoNameReg = regexp.MustCompile(distroRepoNamePattern)
+)
+
+var rpmArches = map[string]bool{"x86_64": true, "aarch64": true, "noarch": true, "i686": true, "armv7hl": true, "ppc64le": true, "s390x": true}
+var debArches = map[string]bool{"amd64": true, "arm64": true, "all": true, "i386": true, "armhf": true, "ppc64el": true, "s390x": true}
+
+// Arches returns the arches of the packages of every format in order, an arch named the same by the formats is returned once.
+func Arches() []string {
+	set := make(map[string]bool)
+	for arch := range rpmArches {
+		set[arch] = true
+	}
+	for arch := range debArches {
+		set[arch] = true
+	}
+
+	arches := make([]string, 0, len(set))
+	for arch := range set {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+	return arches
+}
+
+// distros are the distributions served by PMC in the layout of /<distro>/<release>/..., e.g. /ubuntu/22.04/prod/...
+var distros = map[string]bool{
+	"rhel": true, "centos": true, "fedora": true, "sles": true, "opensuse": true, "amazonlinux": true,
+	"mariner": true, "azurelinux": true, "cbl-mariner": true, "ubuntu": true, "debian": true,
+}
+
+// distroAliases are the former names of the distributions, CBL-Mariner is renamed to Azure Linux since 3.0.
+var distroAliases = map[string]string{
+	"mariner":     "azurelinux",
+	"cbl-mariner": "azurelinux",
+}
+
+// codenames are the versions of the releases named by their codenames, [distro][codename]version.
+var codenames = map[string]map[string]string{
+	"ubuntu": {
+		"trusty": "14.04", "xenial": "16.04", "bionic": "18.04", "focal": "20.04", "jammy": "22.04", "noble": "24.04",
+		"kinetic": "22.10", "lunar": "23.04", "mantic": "23.10", "oracular": "24.10",
+	},
+	"debian": {
+		"stretch": "9", "buster": "10", "bullseye": "11", "bookworm": "12", "trixie": "13",
+	},
+}
+
+// ParsePath parses the path of a download from PMC, the path of anything other than an rpm or a deb package is rejected.
+// The path is either /<distro>/<release>/<channel>/..., or /repos/<repo>/... and /yumrepos/<repo>/... with the repo named as
+// microsoft-<distro>-<release>-<channel> or <distro>-<release>-<channel>-....
+func ParsePath(p string) (Package, error) {
+	p, _, _ = strings.Cut(p, "?")
+	pkg, err := parseFileName(path.Base(p))
+	if err != nil {
+		return Package{}, err
+	}
+	pkg.Distro, pkg.Release = parseRepo(p)
+	return pkg, nil
+}
+
+func parseFileName(name string) (Package, error) {
+	switch {
+	case strings.HasSuffix(name, ".rpm"):
+		result := rpmFileReg.FindStringSubmatch(name)
+		if result == nil {
+			return Package{}, fmt.Errorf("%q is not named as <name>-<version>-<release>.<arch>.rpm", name)
+		}
+		if !rpmArches[result[4]] {
+			return Package{}, fmt.Errorf("%q has an unknown rpm arch %q", name, result[4])
+		}
+		return Package{Format: FormatRPM, Name: result[1], Version: result[2], PackageRelease: result[3], Arch: result[4]}, nil
+	case strings.HasSuffix(name, ".deb"):
+		result := debFileReg.FindStringSubmatch(name)
+		if result == nil {
+			return Package{}, fmt.Errorf("%q is not named as <name>_<version>[-<revision>]_<arch>.deb", name)
+		}
+		if !debArches[result[4]] {
+			return Package{}, fmt.Errorf("%q has an unknown deb arch %q", name, result[4])
+		}
+		return Package{Format: FormatDeb, Name: result[1], Version: result[2], PackageRelease: result[3], Arch: result[4]}, nil
+	default:
+		return Package{}, fmt.Errorf("%q is not an rpm or a deb package", name)
+	}
+}
+
+// parseRepo returns the distro and the release of the repository of a path, they are empty when the layout is unknown.
+// The distro is named by its current name, and the release by its version, e.g. azurelinux of cbl-mariner, 22.04 of jammy.
+func parseRepo(p string) (distro string, release string) {
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	if len(segments) < 3 {
+		return "", ""
+	}
+
+	switch segments[0] {
+	case "repos", "yumrepos":
+		if result := repoNameReg.FindStringSubmatch(segments[1]); result != nil {
+			return normalizeRepo(result[1], result[2])
+		}
+		if result := distroRepoNameReg.FindStringSubmatch(segments[1]); result != nil && distros[result[1]] {
+			return normalizeRepo(result[1], result[2])
+		}
+		return "", ""
+	default:
+		if !distros[segments[0]] {
+			return "", ""
+		}
+		return normalizeRepo(segments[0], segments[1])
+	}
+}
+
+// normalizeRepo names a distro by its current name, and a release by its version if it's a known codename.
+func normalizeRepo(distro, release string) (string, string) {
+	distro, release = strings.ToLower(distro), strings.ToLower(release)
+	if alias, ok := distroAliases[distro]; ok {
+		distro = alias
+	}
+	if version, ok := codenames[distro][release]; ok {
+		release = version
+	}
+	return distro, release
+}
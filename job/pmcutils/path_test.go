@@ -0,0 +1,71 @@
+package pmcutils
+
+import "testing"
+
+func TestParsePath(t *testing.T) {
+	for p, want := range map[string]Package{
+		"/rhel/9/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm": {
+			Format: FormatRPM, Distro: "rhel", Release: "9", Name: "aztfexport", Version: "0.14.0", PackageRelease: "1", Arch: "x86_64",
+		},
+		"/ubuntu/22.04/prod/pool/main/a/aztfexport/aztfexport_0.14.0_amd64.deb": {
+			Format: FormatDeb, Distro: "ubuntu", Release: "22.04", Name: "aztfexport", Version: "0.14.0", Arch: "amd64",
+		},
+		"/repos/microsoft-ubuntu-jammy-prod/pool/main/a/aztfexport/aztfexport_0.14.0-1_arm64.deb": {
+			Format: FormatDeb, Distro: "ubuntu", Release: "22.04", Name: "aztfexport", Version: "0.14.0", PackageRelease: "1", Arch: "arm64",
+		},
+		"/repos/microsoft-debian-bookworm-prod/pool/main/a/aztfexport/aztfexport_0.14.0_amd64.deb": {
+			Format: FormatDeb, Distro: "debian", Release: "12", Name: "aztfexport", Version: "0.14.0", Arch: "amd64",
+		},
+		"/yumrepos/azurelinux-3.0-prod-ms-oss-x86_64/Packages/a/aztfexport-0.14.0-1.azl3.x86_64.rpm": {
+			Format: FormatRPM, Distro: "azurelinux", Release: "3.0", Name: "aztfexport", Version: "0.14.0", PackageRelease: "1.azl3", Arch: "x86_64",
+		},
+		"/yumrepos/cbl-mariner-2.0-prod-Microsoft-aarch64/Packages/a/aztfexport-0.14.0-1.cm2.aarch64.rpm": {
+			Format: FormatRPM, Distro: "azurelinux", Release: "2.0", Name: "aztfexport", Version: "0.14.0", PackageRelease: "1.cm2", Arch: "aarch64",
+		},
+		"/mariner/2.0/prod/Packages/a/aztfexport-0.14.0-1.x86_64.rpm": {
+			Format: FormatRPM, Distro: "azurelinux", Release: "2.0", Name: "aztfexport", Version: "0.14.0", PackageRelease: "1", Arch: "x86_64",
+		},
+		"/yumrepos/azurecore/aztfexport-0.14.0-1-x86_64.rpm": {
+			Format: FormatRPM, Name: "aztfexport", Version: "0.14.0", PackageRelease: "1", Arch: "x86_64",
+		},
+	} {
+		got, err := ParsePath(p)
+		if err != nil {
+			t.Errorf("ParsePath(%q): %v", p, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParsePath(%q) = %+v, want %+v", p, got, want)
+		}
+	}
+
+	for _, p := range []string{
+		"/rhel/9/prod/repodata/repomd.xml",
+		"/rhel/9/prod/Packages/a/aztfexport-latest.rpm",
+		"/rhel/9/prod/Packages/a/aztfexport-0.14.0-1.sparc.rpm",
+	} {
+		if pkg, err := ParsePath(p); err == nil {
+			t.Errorf("ParsePath(%q) = %+v, want an error", p, pkg)
+		}
+	}
+}
+
+func TestArches(t *testing.T) {
+	arches := make(map[string]bool)
+	for _, arch := range Arches() {
+		if arches[arch] {
+			t.Errorf("arch %s is returned twice", arch)
+		}
+		arches[arch] = true
+	}
+	for arch := range rpmArches {
+		if !arches[arch] {
+			t.Errorf("rpm arch %s is missing", arch)
+		}
+	}
+	for arch := range debArches {
+		if !arches[arch] {
+			t.Errorf("deb arch %s is missing", arch)
+		}
+	}
+}
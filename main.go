@@ -5,110 +5,92 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"sync"
-	"time"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
 
-	"aztfy-download-counter/database"
 	"aztfy-download-counter/datasource"
-	"aztfy-download-counter/job"
 	"aztfy-download-counter/job/githubutils"
-
-	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 )
 
-const DBName = "aztfy"
-const HBContainer = "Homebrew"
-const GHContainer = "Github"
-const PMCContainer = "PMC"
-
-var (
-	cosmosdbEndpoint = flag.String("cosmosdb", "", "the endpoint of cosmosdb, saving the statstic data")
-	pmcKustoEndpoint = flag.String("kusto-endpoint", "", "the end point of PMC kusto")
-	pmcStartDate     = flag.String("pmc-start-date", "", "when the start grabing PMC data")
-)
-
-func main() {
-	flag.Parse()
-
-	ctx := context.TODO()
+type command struct {
+	flags *flag.FlagSet
+	short string
+	run   func(ctx context.Context) error
+}
 
-	standardDate := time.Now().UTC().Format(job.TimeFormat)
+var commands = map[string]func() command{
+	"collect":  newCollectCommand,
+	"backfill": newBackfillCommand,
+	"report":   newReportCommand,
+	"export":   newExportCommand,
+	"doctor":   newDoctorCommand,
+}
 
-	dbClient, err := database.AuthDBClient(*cosmosdbEndpoint, DBName)
-	if err != nil {
-		log.Println(fmt.Errorf("init db client error: %+v", err))
-	}
+var logChan = make(chan string)
 
-	logChan := make(chan string)
+func main() {
+	logDone := make(chan struct{})
 	go func(logChan chan string) {
+		defer close(logDone)
 		for message := range logChan {
 			log.Print(message)
 		}
 	}(logChan)
 
-	jobs := []job.Job{
-		job.GithubWorker{
-			Date: standardDate,
-			ContainerInitFunc: func() (*azcosmos.ContainerClient, error) {
-				return dbClient.NewContainer(GHContainer)
-			},
-			Logger: log.New(&logChanWriter{logChan: logChan}, "[GithubWorker]\t", 0),
-		},
-		job.HomebrewWorker{
-			Date:   standardDate,
-			Logger: log.New(&logChanWriter{logChan: logChan}, "[HomebrewWorker]\t", 0),
-			ContainerInitFunc: func() (*azcosmos.ContainerClient, error) {
-				return dbClient.NewContainer(HBContainer)
-			},
-			OsTypes: []database.OsType{
-				database.OsTypeDarwin,
-				database.OsTypeLinux,
-			},
-		},
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
 	}
 
-	if len(*pmcStartDate) == 0 {
-		pmcStartDate = &standardDate
+	newCommand, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "--help" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
 	}
 
-	d, _ := time.Parse(job.TimeFormat, *pmcStartDate)
-	n, _ := time.Parse(job.TimeFormat, standardDate)
-	cnt := n.Sub(d).Hours() / 24
-	log.Println("PMC Start Date:", *pmcStartDate, "Count:", int(cnt)+1)
-	pmcJobs := []job.Job{}
-	for i := 0; i <= int(cnt); i++ {
-		pmcJobs = append(pmcJobs, job.PMCWorker{
-			Date: d.Add(time.Hour * 24 * time.Duration(i)).Format(job.TimeFormat),
-			ContainerInitFunc: func() (*azcosmos.ContainerClient, error) {
-				return dbClient.NewContainer(PMCContainer)
-			},
-			KustoEndpoint: *pmcKustoEndpoint,
-			Logger:        log.New(&logChanWriter{logChan: logChan}, "[PMCWorker]\t", 0),
-		})
+	cmd := newCommand()
+	cmd.flags.Usage = func() {
+		fmt.Fprintf(cmd.flags.Output(), "%s\n\nUsage: %s %s [flags]\n\n", cmd.short, os.Args[0], cmd.flags.Name())
+		cmd.flags.PrintDefaults()
 	}
+	_ = cmd.flags.Parse(os.Args[2:])
+
+	// cancel the running requests on SIGINT and SIGTERM, e.g. when the pipeline agent stops the job.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := cmd.run(ctx)
+	stop()
 
-	singlePmcRun := len(pmcJobs) > 1
-	if !singlePmcRun {
-		jobs = append(jobs, pmcJobs...)
+	// nothing logs once the command returns, print the pending lines of the workers before the error.
+	close(logChan)
+	<-logDone
+	if err != nil {
+		log.Printf("%s: %+v", cmd.flags.Name(), err)
+		os.Exit(1)
 	}
+}
 
-	var wg sync.WaitGroup
-	for _, w := range jobs {
-		wg.Add(1)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
 
-		go func(w job.Job) {
-			defer wg.Done()
-			w.Run(ctx)
-		}(w)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
 	}
-
-	if singlePmcRun {
-		for _, job := range pmcJobs {
-			job.Run(ctx)
-		}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name]().short)
 	}
 
-	wg.Wait()
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+func newLogger(prefix string) *log.Logger {
+	return log.New(&logChanWriter{logChan: logChan}, prefix, 0)
 }
 
 type logChanWriter struct {
@@ -120,8 +102,8 @@ func (w *logChanWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-func FetchGitHubVersionList(ctx context.Context) (map[string][]string, error) {
-	releases, err := datasource.FetchGitHubDownloadCount(ctx)
+func FetchGitHubVersionList(ctx context.Context, client *datasource.GithubClient, assets githubutils.AssetParsers, owner, repo string) (map[string][]string, error) {
+	releases, err := client.FetchDownloadCount(ctx, owner, repo, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -133,15 +115,15 @@ func FetchGitHubVersionList(ctx context.Context) (map[string][]string, error) {
 				continue
 			}
 
-			version, _, arch, err := githubutils.ParseTagName(*a.Name, *a.ContentType)
+			asset, err := assets.Parse(*a.Name)
 			if err != nil {
 				continue
 			}
 
-			if _, ok := output[version]; !ok {
-				output[version] = make([]string, 0)
+			if _, ok := output[asset.Version]; !ok {
+				output[asset.Version] = make([]string, 0)
 			}
-			output[version] = append(output[version], arch)
+			output[asset.Version] = append(output[asset.Version], asset.Arch)
 		}
 	}
 
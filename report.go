@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"aztfy-download-counter/config"
+	"aztfy-download-counter/database"
+	"aztfy-download-counter/job"
+	"aztfy-download-counter/job/pmcutils"
+)
+
+func newReportCommand() command {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	common := registerCommonFlags(fs, false)
+	date := fs.String("date", time.Now().UTC().Format(job.TimeFormat), "the day to summarize")
+
+	return command{
+		flags: fs,
+		short: "Print a summary of the saved download counts of a day, without writing anything.",
+		run: func(ctx context.Context) error {
+			if _, err := time.Parse(job.TimeFormat, *date); err != nil {
+				return fmt.Errorf("invalid -date: %+v", err)
+			}
+
+			cfg, err := common.loadConfig(nil, config.Config.ValidateDatabase)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := withTimeout(ctx, cfg.Timeouts.Run)
+			defer cancel()
+
+			newStore, err := common.storeFactory(ctx, cfg)
+			if err != nil {
+				return fmt.Errorf("init db client error: %+v", err)
+			}
+
+			partitions, err := sourcePartitions(cfg)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			defer w.Flush()
+
+			return errors.Join(
+				reportGithub(ctx, w, newStore, partitions["github"], *date),
+				reportHomebrew(ctx, w, newStore, partitions["homebrew"], *date),
+				reportPMC(ctx, w, newStore, partitions["pmc"], *date),
+			)
+		},
+	}
+}
+
+func reportGithub(ctx context.Context, w io.Writer, newStore func(source string) (database.Store, error), partitions []string, date string) error {
+	store, err := newStore("github")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nGithub %s\nREPO\tOS\tVERSION\tARCH\tFORMAT\tTODAY\tTOTAL\n", date)
+	var errs error
+	today := 0
+	for _, osType := range partitions {
+		items, err := database.QueryItem(ctx, store, osType, date, database.GithubVersion{})
+		errs = errors.Join(errs, err)
+		for _, item := range items {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\n", item.Repo, item.OsType, item.Ver, item.Arch, item.Format, item.TodayCount, item.TotalCount)
+			if item.TodayCount > 0 {
+				today += item.TodayCount
+			}
+		}
+	}
+	fmt.Fprintf(w, "all\t\t\t\t\t%d\t\n", today)
+	return errs
+}
+
+func reportHomebrew(ctx context.Context, w io.Writer, newStore func(source string) (database.Store, error), partitions []string, date string) error {
+	store, err := newStore("homebrew")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nHomebrew %s\nOS\tTODAY\t30D\t90D\t365D\tAPI FAILURE\n", date)
+	var errs error
+	for _, osType := range partitions {
+		items, err := database.QueryItem(ctx, store, osType, date, database.HomebrewVersion{})
+		errs = errors.Join(errs, err)
+		for _, item := range items {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%t\n", item.OsType, item.TodayCount, item.ThirtyDayCount, item.NinetyDayCount, item.OneYearCount, item.ApiFailure)
+		}
+	}
+	return errs
+}
+
+func reportPMC(ctx context.Context, w io.Writer, newStore func(source string) (database.Store, error), partitions []string, date string) error {
+	store, err := newStore("pmc")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nPMC %s\nFORMAT\tVERSION\tARCH\tTODAY\tCLIENTS\tTOTAL\n", date)
+	var errs error
+	// the counts of every format, then of all of them.
+	formats := []string{pmcutils.FormatRPM, pmcutils.FormatDeb}
+	today := make(map[string]int)
+	clients := make(map[string]int)
+	total := make(map[string]int64)
+	// the downloads of every distro release
+	type distroRelease struct{ distro, release string }
+	var distros []distroRelease
+	// the downloads of every class of clients
+	var classes []string
+	classToday := make(map[string]int)
+	classClients := make(map[string]int)
+	distroToday := make(map[distroRelease]int)
+	distroClients := make(map[distroRelease]int)
+	for _, arch := range partitions {
+		items, err := database.QueryItem(ctx, store, arch, date, database.PMCVersion{})
+		errs = errors.Join(errs, err)
+		for _, item := range items {
+			format := item.Format
+			if len(format) == 0 {
+				format = pmcutils.FormatRPM
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", format, item.Ver, item.Arch, item.TodayCount, item.TodayClients, item.TotalCount)
+			for _, k := range []string{format, "all"} {
+				today[k] += item.TodayCount
+				clients[k] += item.TodayClients
+				total[k] += item.TotalCount
+			}
+			for _, c := range item.Classes {
+				if _, ok := classToday[c.Class]; !ok {
+					classes = append(classes, c.Class)
+				}
+				classToday[c.Class] += c.TodayCount
+				classClients[c.Class] += c.TodayClients
+			}
+			for _, d := range item.Distros {
+				k := distroRelease{distro: d.Distro, release: d.Release}
+				if _, ok := distroToday[k]; !ok {
+					distros = append(distros, k)
+				}
+				distroToday[k] += d.TodayCount
+				distroClients[k] += d.TodayClients
+			}
+		}
+	}
+	for _, format := range append(formats, "all") {
+		fmt.Fprintf(w, "%s\t\t\t%d\t%d\t%d\n", format, today[format], clients[format], total[format])
+	}
+
+	fmt.Fprintf(w, "\nPMC classes %s\nCLASS\tTODAY\tCLIENTS\n", date)
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", class, classToday[class], classClients[class])
+	}
+
+	fmt.Fprintf(w, "\nPMC distros %s\nDISTRO\tRELEASE\tTODAY\tCLIENTS\n", date)
+	sort.Slice(distros, func(i, j int) bool {
+		if distroToday[distros[i]] != distroToday[distros[j]] {
+			return distroToday[distros[i]] > distroToday[distros[j]]
+		}
+		return distros[i].distro+"/"+distros[i].release < distros[j].distro+"/"+distros[j].release
+	})
+	for _, k := range distros {
+		distro := k.distro
+		if len(distro) == 0 {
+			distro = "unknown"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", distro, k.release, distroToday[k], distroClients[k])
+	}
+	return errs
+}
@@ -0,0 +1,118 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Policy retries the transient failures of the calls to the external services with a jittered exponential backoff.
+type Policy struct {
+	// MaxAttempts includes the first call, the call is not retried when it's less than 2.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, it doubles on every attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between two attempts, a longer retry-after hint is not waited.
+	MaxDelay time.Duration
+	// Timeout limits every attempt, 0 means no limit other than the deadline of the context.
+	Timeout time.Duration
+	// Logger logs the retries, optional.
+	Logger *log.Logger
+}
+
+// DefaultPolicy returns the policy used when it's not configured.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: 4,
+		BaseDelay:   2 * time.Second,
+		MaxDelay:    time.Minute,
+		Timeout:     time.Minute,
+	}
+}
+
+// Classifier tells whether an error of a service is transient, and how long the service asks to wait before retrying.
+type Classifier func(err error) (retryAfter time.Duration, ok bool)
+
+// Do calls call till it succeeds, returns an error which is not transient by classify, or the attempts run out.
+// Every attempt is called with a context limited by the timeout of the policy, a timed out attempt is retried.
+func (p Policy) Do(ctx context.Context, classify Classifier, call func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := p.attempt(ctx, call)
+		if err == nil {
+			return nil
+		}
+		if attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		retryAfter, ok := time.Duration(0), false
+		var timeoutErr *TimeoutError
+		if errors.As(err, &timeoutErr) {
+			ok = true
+		} else if !errors.Is(err, context.Canceled) {
+			retryAfter, ok = classify(err)
+		}
+		if !ok {
+			return err
+		}
+		wait := p.backoff(attempt)
+		if retryAfter > p.MaxDelay {
+			return fmt.Errorf("asked to retry after %v, not waiting for it: %w", retryAfter, err)
+		}
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+
+		if p.Logger != nil {
+			p.Logger.Printf("attempt %d of %d failed, retry in %v: %+v", attempt, p.MaxAttempts, wait, err)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (p Policy) attempt(ctx context.Context, call func(ctx context.Context) error) error {
+	if p.Timeout <= 0 {
+		return call(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	err := call(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Timeout: p.Timeout, Err: err}
+	}
+	return err
+}
+
+// TimeoutError is returned when an attempt doesn't finish in the timeout of the policy, it's retryable.
+type TimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v: %v", e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// backoff returns a random wait between the half and the whole of the exponential delay of an attempt.
+func (p Policy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if shift := attempt - 1; shift < 32 && p.BaseDelay<<shift < p.MaxDelay {
+		delay = p.BaseDelay << shift
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
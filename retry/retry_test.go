@@ -0,0 +1,77 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func classifyTransient(err error) (time.Duration, bool) {
+	return 0, errors.Is(err, errTransient)
+}
+
+func TestPolicyDo(t *testing.T) {
+	p := Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	calls := 0
+	err := p.Do(context.Background(), classifyTransient, func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("transient failures: %d calls, %v", calls, err)
+	}
+
+	calls = 0
+	errPermanent := errors.New("permanent")
+	err = p.Do(context.Background(), classifyTransient, func(context.Context) error {
+		calls++
+		return errPermanent
+	})
+	if !errors.Is(err, errPermanent) || calls != 1 {
+		t.Fatalf("permanent failure: %d calls, %v", calls, err)
+	}
+
+	calls = 0
+	err = p.Do(context.Background(), classifyTransient, func(context.Context) error {
+		calls++
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) || calls != 3 {
+		t.Fatalf("attempts run out: %d calls, %v", calls, err)
+	}
+}
+
+func TestPolicyDoTimeout(t *testing.T) {
+	p := Policy{MaxAttempts: 2, Timeout: time.Millisecond}
+
+	calls := 0
+	err := p.Do(context.Background(), classifyTransient, func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || calls != 2 {
+		t.Fatalf("timed out attempts: %d calls, %v", calls, err)
+	}
+}
+
+func TestPolicyDoRetryAfter(t *testing.T) {
+	p := Policy{MaxAttempts: 3, MaxDelay: time.Second}
+
+	calls := 0
+	err := p.Do(context.Background(), func(error) (time.Duration, bool) { return time.Hour, true }, func(context.Context) error {
+		calls++
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) || calls != 1 {
+		t.Fatalf("retry after longer than the max delay: %d calls, %v", calls, err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"aztfy-download-counter/config"
+	"aztfy-download-counter/database"
+	"aztfy-download-counter/job/githubutils"
+	"aztfy-download-counter/job/pmcutils"
+)
+
+// sources are the names of the data sources, each of them is saved in its own container.
+var sources = []string{"github", "homebrew", "pmc"}
+
+// githubCache is the pseudo source of the GitHub release list cache.
+const githubCache = "github_cache"
+
+var partitionKeys = map[string]string{
+	"github":    database.GithubPartitionKey,
+	"homebrew":  database.HomebrewPartitionKey,
+	"pmc":       database.PMCPartitionKey,
+	githubCache: database.GithubCachePartitionKey,
+}
+
+// sourcePartitions returns the partition keys of the sources, used to read a whole container.
+// The partitions of GitHub are the OSes of the configured asset rules, and of PMC every arch the worker could write,
+// of the rpm and deb packages.
+func sourcePartitions(cfg config.Config) (map[string][]string, error) {
+	parsers, err := githubutils.NewAssetParsers(cfg.Github.AssetRules())
+	if err != nil {
+		return nil, fmt.Errorf("github.assets: %+v", err)
+	}
+	return map[string][]string{
+		"github":   parsers.OsTypes(),
+		"homebrew": {string(database.OsTypeDarwin), string(database.OsTypeLinux)},
+		"pmc":      pmcutils.Arches(),
+	}, nil
+}
+
+func containerName(cfg config.Config, source string) string {
+	switch source {
+	case "github":
+		return cfg.Database.Containers.Github
+	case "homebrew":
+		return cfg.Database.Containers.Homebrew
+	case "pmc":
+		return cfg.Database.Containers.PMC
+	case githubCache:
+		return cfg.Database.Containers.GithubCache
+	}
+	return ""
+}
+
+// commonFlags are the flags shared by all the commands, the ones set take precedence over the config file.
+type commonFlags struct {
+	configPath       string
+	cosmosdbEndpoint string
+	sqlitePath       string
+	dryRun           bool
+}
+
+func registerCommonFlags(fs *flag.FlagSet, withDryRun bool) *commonFlags {
+	f := &commonFlags{}
+	fs.StringVar(&f.configPath, "config", "", "the path of the config file, the environment variables prefixed with "+config.EnvPrefix+"_ override it")
+	fs.StringVar(&f.cosmosdbEndpoint, "cosmosdb", "", "the endpoint of cosmosdb, saving the statstic data")
+	fs.StringVar(&f.sqlitePath, "sqlite", "", "the path of a sqlite database file, saving the statstic data instead of cosmosdb")
+	if withDryRun {
+		fs.BoolVar(&f.dryRun, "dry-run", false, "print what would have been written to the database instead of writing it")
+	}
+	return f
+}
+
+// loadConfig loads the config file and applies the common flags on it, then the flags of the command by override.
+// The result is validated by validate once all the flags are applied, e.g. by config.Config.ValidateDatabase of the
+// commands only reading the database. override is optional.
+func (f commonFlags) loadConfig(override func(cfg *config.Config) error, validate func(config.Config) error) (config.Config, error) {
+	cfg, err := config.Read(f.configPath)
+	if err != nil {
+		return cfg, err
+	}
+
+	if len(f.cosmosdbEndpoint) != 0 {
+		cfg.Database.CosmosDBEndpoint = f.cosmosdbEndpoint
+	}
+	if len(f.sqlitePath) != 0 {
+		cfg.Database.SQLitePath = f.sqlitePath
+	}
+	if override != nil {
+		if err := override(&cfg); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, validate(cfg)
+}
+
+// withTimeout limits ctx by d, 0 means no limit.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// storeFactory returns a function creating the store of a source on the configured backend.
+// The dry runs only read the backend, a SQLite database is opened read only.
+func (f commonFlags) storeFactory(ctx context.Context, cfg config.Config) (func(source string) (database.Store, error), error) {
+	if f.dryRun {
+		return dryRunStoreFactory(ctx, cfg)
+	}
+	return backendStoreFactory(ctx, cfg)
+}
+
+func backendStoreFactory(ctx context.Context, cfg config.Config) (func(source string) (database.Store, error), error) {
+	if len(cfg.Database.SQLitePath) != 0 {
+		db, err := database.OpenSQLite(cfg.Database.SQLitePath)
+		return func(source string) (database.Store, error) {
+			return newSQLiteStore(ctx, db, containerName(cfg, source), source)
+		}, err
+	}
+
+	return cosmosStoreFactory(cfg)
+}
+
+func cosmosStoreFactory(cfg config.Config) (func(source string) (database.Store, error), error) {
+	dbClient, err := database.AuthDBClient(cfg.Database.CosmosDBEndpoint, cfg.Database.Name)
+	return func(source string) (database.Store, error) {
+		return database.NewCosmosStore(dbClient, containerName(cfg, source), cfg.Database.Retry.Policy())
+	}, err
+}
+
+// newSQLiteStore returns the store of a source on a table typed by the items of the source.
+func newSQLiteStore(ctx context.Context, db *sql.DB, table, source string) (database.Store, error) {
+	pk := partitionKeys[source]
+	switch source {
+	case "github":
+		return database.NewSQLiteStore[database.GithubVersion](ctx, db, table, pk)
+	case "homebrew":
+		return database.NewSQLiteStore[database.HomebrewVersion](ctx, db, table, pk)
+	case "pmc":
+		return database.NewSQLiteStore[database.PMCVersion](ctx, db, table, pk)
+	case githubCache:
+		return database.NewSQLiteStore[database.GithubReleasePage](ctx, db, table, pk)
+	}
+	return nil, fmt.Errorf("unknown source %q", source)
+}
+
+// newReadOnlySQLiteStore is newSQLiteStore of an existing table, nil is returned when the table doesn't exist.
+func newReadOnlySQLiteStore(ctx context.Context, db *sql.DB, table, source string) (database.Store, error) {
+	pk := partitionKeys[source]
+	var store database.SQLiteStore
+	var err error
+	switch source {
+	case "github":
+		store, err = database.NewReadOnlySQLiteStore[database.GithubVersion](ctx, db, table, pk)
+	case "homebrew":
+		store, err = database.NewReadOnlySQLiteStore[database.HomebrewVersion](ctx, db, table, pk)
+	case "pmc":
+		store, err = database.NewReadOnlySQLiteStore[database.PMCVersion](ctx, db, table, pk)
+	case githubCache:
+		store, err = database.NewReadOnlySQLiteStore[database.GithubReleasePage](ctx, db, table, pk)
+	default:
+		return nil, fmt.Errorf("unknown source %q", source)
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// dryRunStoreFactory returns a function creating the stores which read the backend, but only print what would have
+// been written to it. Nothing is changed in the backend, the tables missing in a SQLite database are read as empty.
+func dryRunStoreFactory(ctx context.Context, cfg config.Config) (func(source string) (database.Store, error), error) {
+	var newBase func(source string) (database.Store, error)
+	var err error
+	if len(cfg.Database.SQLitePath) != 0 {
+		var db *sql.DB
+		db, err = database.OpenSQLiteReadOnly(cfg.Database.SQLitePath)
+		newBase = func(source string) (database.Store, error) {
+			return newReadOnlySQLiteStore(ctx, db, containerName(cfg, source), source)
+		}
+	} else {
+		newBase, err = cosmosStoreFactory(cfg)
+	}
+
+	return func(source string) (database.Store, error) {
+		base, err := newBase(source)
+		if err != nil {
+			return nil, err
+		}
+		logger := newLogger(fmt.Sprintf("[DryRun:%s]\t", source))
+		return database.NewDryRunStore(base, partitionKeys[source], logger), nil
+	}, err
+}